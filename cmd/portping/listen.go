@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sopov/portping/internal/cli"
+	"github.com/sopov/portping/internal/colors"
+	"github.com/sopov/portping/internal/echo"
+	"net"
+	"os"
+	"sync"
+)
+
+// listen runs "portping listen": an echo reflector for UDP and TCP pings
+// that serves until ctx is canceled.
+func listen(ctx context.Context, args []string) int {
+	cfg, err := cli.ParseListen(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+		return exitUsage
+	}
+
+	// the first failing server stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var serves []func() error
+	if cfg.UDP != "" {
+		conn, err := net.ListenPacket("udp", cfg.UDP)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+			return exitRuntime
+		}
+		stamps := ""
+		if cfg.Timestamp {
+			stamps = " with timestamps"
+		}
+		fmt.Fprintf(os.Stderr, "Echo on udp %s%s\n", conn.LocalAddr(), stamps)
+		serves = append(serves, func() error { return echo.ServeUDP(ctx, conn, cfg.Timestamp) })
+	}
+	if cfg.TCP != "" {
+		ln, err := net.Listen("tcp", cfg.TCP)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+			return exitRuntime
+		}
+		fmt.Fprintf(os.Stderr, "Echo on tcp %s\n", ln.Addr())
+		serves = append(serves, func() error { return echo.ServeTCP(ctx, ln) })
+	}
+
+	code := exitOK
+	var once sync.Once
+	var wg sync.WaitGroup
+	for _, serve := range serves {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serve(); err != nil {
+				once.Do(func() {
+					fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+					code = exitRuntime
+				})
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	return code
+}
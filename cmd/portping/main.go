@@ -8,6 +8,8 @@ import (
 	"github.com/sopov/portping/internal/app"
 	"github.com/sopov/portping/internal/cli"
 	"github.com/sopov/portping/internal/colors"
+	"github.com/sopov/portping/internal/models"
+	"github.com/sopov/portping/internal/stats"
 	"os"
 	"os/signal"
 	"syscall"
@@ -17,6 +19,9 @@ const (
 	exitOK       = 0
 	exitUsage    = 2
 	exitRuntime  = 1
+	exitNotMet   = 3   // -wait/-wait-down condition not met in time
+	exitWarning  = 4   // a -max-* warning threshold was exceeded
+	exitCritical = 5   // a -max-* critical threshold was exceeded, or no data
 	exitCanceled = 130 // 128+SIGINT
 )
 
@@ -27,18 +32,52 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			os.Exit(serve(ctx, os.Args[2:]))
+		case "listen":
+			os.Exit(listen(ctx, os.Args[2:]))
+		}
+	}
+
 	cfg, err := cli.Parse()
 	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			fmt.Fprintln(os.Stdout, cli.Usage())
 			os.Exit(exitOK)
 		}
+		if cli.NagiosRequested(os.Args[1:]) {
+			nagiosUnknown(err)
+		}
 		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
 		fmt.Fprintln(os.Stderr, cli.Usage())
 		os.Exit(exitUsage)
 	}
 
-	if err := app.NewApp(ctx, cfg).Run(); err != nil {
+	reporters, closeAll, err := openReporters(cfg)
+	if err != nil {
+		if nagiosOutput(cfg) {
+			nagiosUnknown(err)
+		}
+		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+		os.Exit(exitRuntime)
+	}
+
+	a := app.NewApp(ctx, cfg, reporters...)
+	err = a.Run()
+	closeAll()
+	// the plugin state is the exit code, whatever ended the session
+	for _, r := range reporters {
+		if n, ok := r.(*stats.NagiosReporter); ok {
+			os.Exit(int(n.Status()))
+		}
+	}
+	if errors.Is(err, app.ErrNotMet) {
+		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+		os.Exit(exitNotMet)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
 		os.Exit(exitRuntime)
 	}
@@ -46,6 +85,63 @@ func main() {
 	if ctx.Err() != nil {
 		os.Exit(exitCanceled)
 	}
+
+	switch a.Status() {
+	case models.StatusOK:
+	case models.StatusWarning:
+		os.Exit(exitWarning)
+	default:
+		os.Exit(exitCritical)
+	}
+}
+
+// openReporters builds a reporter per configured output, creating output
+// files as needed. The returned func closes all opened files.
+func openReporters(cfg *models.Config) ([]stats.Reporter, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+
+	reporters := make([]stats.Reporter, 0, len(cfg.Outputs))
+	for _, o := range cfg.Outputs {
+		w := os.Stdout
+		if o.Path != "" {
+			f, err := os.Create(o.Path)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			files = append(files, f)
+			w = f
+		}
+		// colors only make sense on a terminal
+		r, err := stats.NewReporter(o.Format, w, o.Path == "" && !cfg.NoColor)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	return reporters, closeAll, nil
+}
+
+// nagiosOutput reports whether cfg writes a Nagios status line.
+func nagiosOutput(cfg *models.Config) bool {
+	for _, o := range cfg.Outputs {
+		if o.Format == models.OutputNagios {
+			return true
+		}
+	}
+	return false
+}
+
+// nagiosUnknown prints err as an UNKNOWN plugin status line and exits.
+func nagiosUnknown(err error) {
+	fmt.Fprintf(os.Stdout, "PORTPING %s - %s\n", models.StatusUnknown, err)
+	os.Exit(int(models.StatusUnknown))
 }
 
 func version() {
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sopov/portping/internal/app"
+	"github.com/sopov/portping/internal/cli"
+	"github.com/sopov/portping/internal/colors"
+	"github.com/sopov/portping/internal/exporter"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"os"
+)
+
+// serve runs "portping serve": a Prometheus exporter that pings the given
+// targets continuously and answers one-shot probes until ctx is canceled.
+func serve(ctx context.Context, args []string) int {
+	cfg, listen, err := cli.ParseServe(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+		return exitUsage
+	}
+
+	// the session updates its config, probes are built from a snapshot
+	base := *cfg
+	e := exporter.New(func(target, preset string) (*models.Config, error) {
+		return cli.ProbeTarget(&base, target, preset)
+	})
+
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+		return exitRuntime
+	}
+	if cfg.Host != "" || len(cfg.Targets) > 0 {
+		go func() {
+			if err := app.NewApp(ctx, cfg, e).Run(); err != nil {
+				fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+			}
+		}()
+	}
+	fmt.Fprintf(os.Stderr, "Serving /metrics and /probe on %s\n", ln.Addr())
+	if err := e.Serve(ctx, ln); err != nil {
+		fmt.Fprintln(os.Stderr, colors.Red(err.Error()))
+		return exitRuntime
+	}
+	return exitOK
+}
@@ -2,10 +2,15 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/sopov/portping/internal/models"
 	"github.com/sopov/portping/internal/probe"
 	"github.com/sopov/portping/internal/stats"
 	"net"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -16,39 +21,154 @@ var Commit = ""
 var BuildDate = ""
 
 type App struct {
-	ctx   context.Context
-	cfg   *models.Config
-	stats map[string]*models.Stats
+	ctx     context.Context
+	cfg     *models.Config
+	targets []*target
+	report  stats.Reporter
+
+	// sessions are the open two-way echo sessions of -twamp targets.
+	sessionsMu sync.Mutex
+	sessions   map[sessionKey]*probe.EchoSession
+}
+
+// sessionKey identifies the two-way echo session to an address of a target.
+type sessionKey struct {
+	cfg     *models.Config
+	address string
 }
 
-func NewApp(ctx context.Context, cfg *models.Config) *App {
+// target is a destination of the session with its per-IP statistics.
+type target struct {
+	cfg      *models.Config
+	stats    map[string]*models.Stats
+	resolved time.Time
+	// stop is the condition that ended the pings of the target.
+	stop models.StopReason
+	// successes and failStreak count the successful attempts and the
+	// current run of failed attempts.
+	successes  int
+	failStreak int
+}
+
+func newTarget(cfg *models.Config) *target {
+	t := &target{
+		cfg:      cfg,
+		stats:    make(map[string]*models.Stats, len(cfg.IPs)),
+		resolved: time.Now(),
+	}
+	for _, ip := range cfg.IPs {
+		t.stats[ip.IP] = &models.Stats{IP: ip}
+	}
+	if cfg.HappyEyeballs {
+		t.stats[models.RaceKey] = &models.Stats{}
+	}
+	return t
+}
+
+// NewApp creates an App that sends its events to the given reporters.
+// Without reporters the session is rendered as text on stdout.
+func NewApp(ctx context.Context, cfg *models.Config, reporters ...stats.Reporter) *App {
+	report := stats.Reporter(stats.NewTextReporter(os.Stdout, !cfg.NoColor))
+	switch len(reporters) {
+	case 0:
+	case 1:
+		report = reporters[0]
+	default:
+		report = stats.Multi(reporters)
+	}
 	return &App{
-		ctx:   ctx,
-		cfg:   cfg,
-		stats: make(map[string]*models.Stats, len(cfg.IPs)),
+		ctx:    ctx,
+		cfg:    cfg,
+		report: report,
 	}
 }
 
+// ErrNotMet is returned by Run when a wait-for session ends because of
+// its deadline or count before every target met the condition.
+var ErrNotMet = errors.New("wait condition not met")
+
+// Run pings every IP of every target once per round. Reporters see all
+// targets start before the first result and get one summary per target.
+// Port sweeps are run once before the rounds of the remaining targets.
 func (a *App) Run() error {
-	defer stats.ShowStats(a.cfg, a.stats)
-	stats.ShowBanner(a.cfg)
+	parent := a.ctx
+	if a.cfg.Deadline > 0 {
+		ctx, cancel := context.WithTimeout(parent, a.cfg.Deadline)
+		defer cancel()
+		a.ctx = ctx
+		defer func() { a.ctx = parent }()
+	}
 
-	pingOpts := make(map[string]models.PingOptions, len(a.cfg.IPs))
-	maxIPLen := 0
-	for _, ip := range a.cfg.IPs {
-		pingOpts[ip.IP] = models.PingOptions{
-			Context: a.ctx,
-			Config:  a.cfg,
-			Address: net.JoinHostPort(ip.IP, a.cfg.Port),
-			Payload: a.cfg.UDPPayload,
+	a.targets = a.targets[:0]
+	for _, cfg := range a.cfg.TargetList() {
+		a.targets = append(a.targets, newTarget(cfg))
+	}
+	defer func() {
+		for _, t := range a.targets {
+			t.cfg.Stop = t.stop
+			a.closeSessions(t)
+			a.report.OnSummary(t.cfg, t.stats)
+		}
+	}()
+	var pinged []*target
+	for _, t := range a.targets {
+		a.report.OnStart(t.cfg)
+		if !t.cfg.IsSweep() {
+			pinged = append(pinged, t)
 		}
-		a.stats[ip.IP] = &models.Stats{IP: ip}
-		if l := len(ip.IP); l > maxIPLen {
-			maxIPLen = l
+	}
+	for _, t := range a.targets {
+		if t.cfg.IsSweep() {
+			a.sweep(t)
 		}
 	}
-	singleIP := len(a.cfg.IPs) == 1
+	if len(pinged) == 0 {
+		return nil
+	}
 
+	attempts := a.rounds(pinged)
+	reason := models.StopCount
+	switch {
+	case parent.Err() != nil:
+		reason = models.StopInterrupted
+	case a.ctx.Err() != nil:
+		reason = models.StopDeadline
+	}
+	met := true
+	for _, t := range pinged {
+		if t.stop == "" {
+			t.stop = reason
+		}
+		met = met && t.stop == models.StopWait
+	}
+	if a.cfg.Wait == "" || met || reason == models.StopInterrupted {
+		return nil
+	}
+	if reason == models.StopDeadline {
+		return fmt.Errorf("%w: deadline of %s expired", ErrNotMet, a.cfg.Deadline)
+	}
+	noun := "attempts"
+	if attempts == 1 {
+		noun = "attempt"
+	}
+	return fmt.Errorf("%w after %d %s", ErrNotMet, attempts, noun)
+}
+
+// Status checks the statistics of the last Run against the -max-* limits
+// and returns the worst status of all targets.
+func (a *App) Status() models.Status {
+	status := models.StatusOK
+	for _, t := range a.targets {
+		st, _ := stats.Check(t.cfg, t.stats)
+		status = max(status, st)
+	}
+	return status
+}
+
+// rounds pings the targets until the count is reached, the session is
+// interrupted or every target met a stop condition, and returns the number
+// of rounds that were started.
+func (a *App) rounds(pinged []*target) int {
 	var attempt int
 	timer := time.NewTimer(0)
 	if !timer.Stop() {
@@ -58,32 +178,70 @@ func (a *App) Run() error {
 
 	for {
 		if !a.cfg.Nonstop && attempt >= a.cfg.Count {
-			break
+			return attempt
+		}
+		targets := a.pending(pinged)
+		if len(targets) == 0 {
+			return attempt
 		}
 		attempt++
 		batchStart := time.Now()
 
-		for idx, ip := range a.cfg.IPs {
-			select {
-			case <-a.ctx.Done():
-				return nil
-			default:
-			}
-
-			// per-ping timeout context
-			ctx, cancel := context.WithTimeout(a.ctx, a.cfg.TimeoutDur)
-			opts := pingOpts[ip.IP]
-			opts.Context = ctx
-
-			t, err := a.Ping(opts)
-			cancel()
+		a.reresolve(targets)
+		probes := 0
+		for _, t := range targets {
+			probes += t.probes()
+		}
+		if a.cfg.Parallel {
+			a.round(targets, attempt, probes)
+		} else {
+			sub := 0
+			for _, t := range targets {
+				if t.cfg.HappyEyeballs {
+					res := a.race(t)
+					if a.ctx.Err() != nil {
+						return attempt // interrupted, the outcome is unknown
+					}
+					res.Attempt = attempt
+					sub++
+					if probes > 1 {
+						res.Sub = sub
+					}
+					stats.Add(t.stats[models.RaceKey], res)
+					if res.Err == nil {
+						stats.Add(t.stats[res.IP.IP], res)
+					}
+					a.report.OnResult(t.cfg, res)
+					a.settle(t, res)
+					continue
+				}
+				for _, ip := range t.active() {
+					if t.stop != "" {
+						break
+					}
+					select {
+					case <-a.ctx.Done():
+						return attempt
+					default:
+					}
 
-			stats.Update(a.stats[ip.IP], t, err)
-			sub := idx + 1
-			if singleIP {
-				sub = 0
+					res := a.probe(t.cfg, ip, t.cfg.Port)
+					if a.ctx.Err() != nil {
+						return attempt // interrupted, the outcome is unknown
+					}
+					res.Attempt = attempt
+					sub++
+					if probes > 1 {
+						res.Sub = sub
+					}
+					stats.Add(t.stats[ip.IP], res)
+					a.report.OnResult(t.cfg, res)
+					a.settle(t, res)
+				}
 			}
-			stats.ShowCurrent(a.cfg, attempt, sub, ip.IP, maxIPLen, t, err)
+		}
+		if a.ctx.Err() != nil || len(a.pending(pinged)) == 0 {
+			return attempt
 		}
 		if a.cfg.Nonstop || attempt < a.cfg.Count {
 			if since := time.Since(batchStart); since < a.cfg.DelayDur {
@@ -97,19 +255,252 @@ func (a *App) Run() error {
 				timer.Reset(wait)
 				select {
 				case <-a.ctx.Done():
-					return nil
+					return attempt
 				case <-timer.C:
 				}
 			}
 		}
 	}
-	return nil
 }
 
+// pending returns the targets that did not meet a stop condition yet.
+func (a *App) pending(targets []*target) []*target {
+	out := make([]*target, 0, len(targets))
+	for _, t := range targets {
+		if t.stop == "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// settle accounts res for the stop conditions of t: the -wait condition,
+// -successes and -fail-streak.
+func (a *App) settle(t *target, res models.Result) {
+	if res.Err == nil {
+		t.successes++
+		t.failStreak = 0
+	} else {
+		t.failStreak++
+	}
+	switch {
+	case t.stop != "":
+	case a.cfg.Wait == models.WaitUp && res.Err == nil,
+		a.cfg.Wait == models.WaitDown && res.Err != nil:
+		t.stop = models.StopWait
+	case a.cfg.Successes > 0 && t.successes >= a.cfg.Successes:
+		t.stop = models.StopSuccesses
+	case a.cfg.FailStreak > 0 && t.failStreak >= a.cfg.FailStreak:
+		t.stop = models.StopFailures
+	}
+}
+
+// sweep probes every port of every IP of t once, running up to
+// cfg.Workers probes at a time, and reports the results ordered by IP and
+// port.
+func (a *App) sweep(t *target) {
+	jobs := make([]job, 0, len(t.cfg.IPs)*len(t.cfg.Ports))
+	for _, ip := range t.cfg.IPs {
+		for _, port := range t.cfg.Ports {
+			jobs = append(jobs, job{t: t, ip: ip, port: strconv.Itoa(port)})
+		}
+	}
+	for i, res := range a.probeAll(jobs, t.cfg.Workers) {
+		if res == nil {
+			continue
+		}
+		res.Attempt = 1
+		res.Sub = i + 1
+		res.State = probe.PortState(t.cfg.Proto, res.ErrClass)
+		stats.Add(t.stats[res.IP.IP], *res)
+		a.report.OnResult(t.cfg, *res)
+	}
+}
+
+// round probes all IPs of the targets concurrently and reports them in
+// the same order as a sequential round.
+func (a *App) round(targets []*target, attempt, probes int) {
+	var jobs []job
+	for _, t := range targets {
+		for _, ip := range t.active() {
+			jobs = append(jobs, job{t: t, ip: ip, port: t.cfg.Port})
+		}
+	}
+	for i, res := range a.probeAll(jobs, a.cfg.Workers) {
+		if res == nil {
+			continue
+		}
+		res.Attempt = attempt
+		if probes > 1 {
+			res.Sub = i + 1
+		}
+		t := jobs[i].t
+		stats.Add(t.stats[res.IP.IP], *res)
+		a.report.OnResult(t.cfg, *res)
+		a.settle(t, *res)
+	}
+}
+
+// job is a single probe of a concurrent batch.
+type job struct {
+	t    *target
+	ip   models.IP
+	port string
+}
+
+// probeAll runs the jobs with up to workers probes at a time and returns
+// their results in job order, nil for jobs that were not completed because
+// the session was interrupted. Workers only fill their own result slot;
+// statistics and reporters are updated by the caller afterwards, so the
+// accounting is never concurrent and keeps a stable order.
+func (a *App) probeAll(jobs []job, workers int) []*models.Result {
+	results := make([]*models.Result, len(jobs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for range max(1, min(workers, len(jobs))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				j := jobs[i]
+				res := a.probe(j.t.cfg, j.ip, j.port)
+				if a.ctx.Err() != nil {
+					continue // interrupted, the outcome is unknown
+				}
+				results[i] = &res
+			}
+		}()
+	}
+
+feed:
+	for i := range jobs {
+		select {
+		case <-a.ctx.Done():
+			break feed
+		case next <- i:
+		}
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// probe pings port of ip once with the per-ping timeout and returns the
+// classified result.
+func (a *App) probe(cfg *models.Config, ip models.IP, port string) models.Result {
+	ctx, cancel := context.WithTimeout(a.ctx, cfg.TimeoutDur)
+	defer cancel()
+	opts := models.PingOptions{
+		Context: ctx,
+		Config:  cfg,
+		Address: net.JoinHostPort(ip.IP, port),
+		Payload: cfg.UDPPayload,
+		Dialer:  probe.NewDialer(cfg),
+		Details: &models.Details{},
+	}
+
+	started := time.Now()
+	d, err := a.Ping(opts)
+	res := models.Result{
+		IP:       ip,
+		Port:     port,
+		Time:     started,
+		Duration: d,
+		Err:      err,
+		ErrClass: probe.ClassifyError(err),
+		Details:  opts.Details,
+	}
+	if cfg.IsUDP() {
+		// like nmap: a reply is open, an ICMP port unreachable closed and
+		// silence open|filtered
+		res.State = probe.PortState(models.UDP, res.ErrClass)
+	}
+	return res
+}
+
+// race runs a single Happy Eyeballs attempt over all IPs of t.
+func (a *App) race(t *target) models.Result {
+	ctx, cancel := context.WithTimeout(a.ctx, t.cfg.TimeoutDur)
+	defer cancel()
+	opts := models.PingOptions{
+		Context: ctx,
+		Config:  t.cfg,
+		Dialer:  probe.NewDialer(t.cfg),
+		Details: &models.Details{},
+	}
+
+	started := time.Now()
+	ip, d, err := probe.PingHappyEyeballs(opts, probe.InterleaveFamilies(t.active()))
+	return models.Result{
+		IP:       ip,
+		Port:     t.cfg.Port,
+		Time:     started,
+		Duration: d,
+		Err:      err,
+		ErrClass: probe.ClassifyError(err),
+		Details:  opts.Details,
+	}
+}
+
+// Ping runs a single probe with the protocol of the target in opts.
 func (a *App) Ping(opts models.PingOptions) (time.Duration, error) {
-	if a.cfg.IsTCP() {
+	cfg := opts.Config
+	if cfg.TWAMP {
+		s, err := a.session(cfg, opts.Address)
+		if err != nil {
+			return 0, err
+		}
+		return s.Ping(opts)
+	}
+	if cfg.IsHTTP() {
+		return probe.PingHTTP(opts)
+	}
+	if cfg.IsTCP() {
+		if cfg.TLS {
+			return probe.PingTLS(opts)
+		}
 		return probe.PingTCP(opts)
 	}
 	return probe.PingUDP(opts)
 
 }
+
+// session returns the two-way echo session to address, opening it on
+// first use.
+func (a *App) session(cfg *models.Config, address string) (*probe.EchoSession, error) {
+	a.sessionsMu.Lock()
+	defer a.sessionsMu.Unlock()
+	key := sessionKey{cfg: cfg, address: address}
+	if s := a.sessions[key]; s != nil {
+		return s, nil
+	}
+	s, err := probe.NewEchoSession(a.ctx, probe.NewDialer(cfg), address)
+	if err != nil {
+		return nil, err
+	}
+	if a.sessions == nil {
+		a.sessions = make(map[sessionKey]*probe.EchoSession)
+	}
+	a.sessions[key] = s
+	return s, nil
+}
+
+// closeSessions ends the two-way echo sessions of t and keeps their
+// counters in the statistics of the IPs.
+func (a *App) closeSessions(t *target) {
+	a.sessionsMu.Lock()
+	defer a.sessionsMu.Unlock()
+	for key, s := range a.sessions {
+		if key.cfg != t.cfg {
+			continue
+		}
+		_ = s.Close()
+		host, _, _ := net.SplitHostPort(key.address)
+		if st := t.stats[host]; st != nil {
+			seq := s.Stats()
+			st.Seq = &seq
+		}
+		delete(a.sessions, key)
+	}
+}
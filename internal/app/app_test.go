@@ -2,11 +2,32 @@ package app
 
 import (
 	"context"
+	"errors"
+	"github.com/sopov/portping/internal/echo"
 	"github.com/sopov/portping/internal/models"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
+type recordingReporter struct {
+	starts    int
+	results   []models.Result
+	events    []models.Event
+	summaries int
+}
+
+func (r *recordingReporter) OnStart(_ *models.Config) { r.starts++ }
+func (r *recordingReporter) OnResult(_ *models.Config, res models.Result) {
+	r.results = append(r.results, res)
+}
+func (r *recordingReporter) OnEvent(_ *models.Config, ev models.Event) {
+	r.events = append(r.events, ev)
+}
+func (r *recordingReporter) OnSummary(_ *models.Config, _ map[string]*models.Stats) { r.summaries++ }
+
 func TestNewApp(t *testing.T) {
 	ctx := context.Background()
 	cfg := &models.Config{
@@ -27,8 +48,8 @@ func TestNewApp(t *testing.T) {
 	if a.cfg != cfg {
 		t.Error("NewApp() cfg not set correctly")
 	}
-	if len(a.stats) != 0 {
-		t.Errorf("NewApp() stats should be empty, got %d entries", len(a.stats))
+	if len(a.targets) != 0 {
+		t.Errorf("NewApp() targets should be empty, got %d entries", len(a.targets))
 	}
 }
 
@@ -152,8 +173,455 @@ func TestApp_Run_AddressFormat(t *testing.T) {
 		t.Errorf("App.Run() error = %v, expected nil", err)
 	}
 	// Verify that stats are created for all IPs
-	if len(a.stats) != len(cfg.IPs) {
-		t.Errorf("App.Run() stats should have %d entries, got %d", len(cfg.IPs), len(a.stats))
+	if len(a.targets) != 1 || len(a.targets[0].stats) != len(cfg.IPs) {
+		t.Fatalf("App.Run() should keep stats of %d IPs in one target", len(cfg.IPs))
+	}
+}
+
+func TestApp_Run_Reporters(t *testing.T) {
+	cfg := &models.Config{
+		Proto: models.TCP,
+		IPs: []models.IP{
+			{IP: "127.0.0.1", IsIPv4: true},
+		},
+		Port:       "1",
+		Count:      2,
+		TimeoutDur: 50 * time.Millisecond,
+		DelayDur:   10 * time.Millisecond,
+	}
+	first, second := &recordingReporter{}, &recordingReporter{}
+
+	if err := NewApp(context.Background(), cfg, first, second).Run(); err != nil {
+		t.Fatalf("App.Run() error = %v, expected nil", err)
+	}
+
+	for i, r := range []*recordingReporter{first, second} {
+		if r.starts != 1 || r.summaries != 1 {
+			t.Errorf("reporter %d: starts=%d summaries=%d, expected 1/1", i, r.starts, r.summaries)
+		}
+		if len(r.results) != 2 {
+			t.Fatalf("reporter %d: got %d results, expected 2", i, len(r.results))
+		}
+		if r.results[1].Attempt != 2 || r.results[1].IP.IP != "127.0.0.1" {
+			t.Errorf("reporter %d: unexpected result %+v", i, r.results[1])
+		}
+	}
+}
+
+func TestApp_Run_MultipleTargets(t *testing.T) {
+	target := func(port string) *models.Config {
+		return &models.Config{
+			Proto:      models.TCP,
+			Host:       "127.0.0.1",
+			Port:       port,
+			IPs:        []models.IP{{IP: "127.0.0.1", IsIPv4: true}},
+			TimeoutDur: 50 * time.Millisecond,
+		}
+	}
+	cfg := &models.Config{
+		Count:      2,
+		TimeoutDur: 50 * time.Millisecond,
+		DelayDur:   10 * time.Millisecond,
+		Targets:    []*models.Config{target("1"), target("2")},
+	}
+	r := &recordingReporter{}
+
+	a := NewApp(context.Background(), cfg, r)
+	if err := a.Run(); err != nil {
+		t.Fatalf("App.Run() error = %v, expected nil", err)
+	}
+
+	if r.starts != 2 || r.summaries != 2 {
+		t.Errorf("starts=%d summaries=%d, expected one per target", r.starts, r.summaries)
+	}
+	if len(r.results) != 4 {
+		t.Fatalf("got %d results, expected 4", len(r.results))
+	}
+	if r.results[1].Sub != 2 || r.results[2].Attempt != 2 || r.results[2].Sub != 1 {
+		t.Errorf("unexpected numbering: %+v", r.results)
+	}
+	for i, tg := range a.targets {
+		if st := tg.stats["127.0.0.1"]; st == nil || st.Attempts != 2 {
+			t.Errorf("target %d: unexpected stats %+v", i, st)
+		}
+	}
+}
+
+func TestApp_Run_Sweep(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	open := ln.Addr().(*net.TCPAddr).Port
+
+	cfg := &models.Config{
+		Proto:      models.TCP,
+		IPs:        []models.IP{{IP: "127.0.0.1", IsIPv4: true}},
+		Ports:      []int{1, open},
+		Workers:    4,
+		Count:      3,
+		TimeoutDur: 200 * time.Millisecond,
+	}
+	r := &recordingReporter{}
+	a := NewApp(context.Background(), cfg, r)
+	if err := a.Run(); err != nil {
+		t.Fatalf("App.Run() error = %v, expected nil", err)
+	}
+
+	if len(r.results) != 2 {
+		t.Fatalf("sweep should probe every port once, got %d results", len(r.results))
+	}
+	if r.results[0].Port != "1" || r.results[0].State != models.StateClosed {
+		t.Errorf("unexpected first result %+v", r.results[0])
+	}
+	if r.results[1].State != models.StateOpen {
+		t.Errorf("unexpected second result %+v", r.results[1])
+	}
+	if st := a.targets[0].stats["127.0.0.1"]; st.States[models.StateOpen] != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestApp_Run_Parallel(t *testing.T) {
+	// a silent UDP socket makes every probe wait for its full timeout
+	pc, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = pc.Close() }()
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+
+	cfg := &models.Config{
+		Proto: models.UDP,
+		IPs: []models.IP{
+			{IP: "127.0.0.1", IsIPv4: true},
+			{IP: "127.0.0.2", IsIPv4: true},
+			{IP: "127.0.0.3", IsIPv4: true},
+			{IP: "127.0.0.4", IsIPv4: true},
+		},
+		Port:       port,
+		UDPPayload: []byte("ping"),
+		Count:      1,
+		Parallel:   true,
+		Workers:    4,
+		TimeoutDur: 200 * time.Millisecond,
+	}
+	r := &recordingReporter{}
+
+	started := time.Now()
+	if err := NewApp(context.Background(), cfg, r).Run(); err != nil {
+		t.Fatalf("App.Run() error = %v, expected nil", err)
+	}
+	if elapsed := time.Since(started); elapsed > 600*time.Millisecond {
+		t.Errorf("parallel round took %v, expected about one timeout", elapsed)
+	}
+
+	if len(r.results) != len(cfg.IPs) {
+		t.Fatalf("got %d results, expected %d", len(r.results), len(cfg.IPs))
+	}
+	for i, res := range r.results {
+		if res.Sub != i+1 || res.IP != cfg.IPs[i] || res.ErrClass != models.ErrTimeout {
+			t.Errorf("result %d out of order or unexpected: %+v", i, res)
+		}
+	}
+}
+
+func TestApp_Run_HappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	cfg := &models.Config{
+		Proto: models.TCP,
+		IPs: []models.IP{
+			{IP: "127.0.0.1", IsIPv4: true},
+			{IP: "::1"},
+		},
+		Port:          port,
+		Count:         2,
+		HappyEyeballs: true,
+		TimeoutDur:    time.Second,
+		DelayDur:      10 * time.Millisecond,
+	}
+	r := &recordingReporter{}
+	a := NewApp(context.Background(), cfg, r)
+	if err := a.Run(); err != nil {
+		t.Fatalf("App.Run() error = %v, expected nil", err)
+	}
+
+	if len(r.results) != 2 {
+		t.Fatalf("expected one result per attempt, got %d", len(r.results))
+	}
+	if r.results[0].IP.IP != "127.0.0.1" || r.results[0].Sub != 0 {
+		t.Errorf("unexpected result %+v", r.results[0])
+	}
+	st := a.targets[0].stats
+	if st[models.RaceKey].Attempts != 2 || st["127.0.0.1"].Connects != 2 || st["::1"].Attempts != 0 {
+		t.Errorf("unexpected race stats %+v / %+v / %+v", st[models.RaceKey], st["127.0.0.1"], st["::1"])
+	}
+}
+
+func TestApp_Run_Reresolve(t *testing.T) {
+	cfg := &models.Config{
+		Proto:      models.TCP,
+		Host:       "localhost",
+		Port:       "1",
+		AllowIPv4:  true,
+		IPs:        []models.IP{{IP: "192.0.2.7", IsIPv4: true}},
+		Count:      2,
+		Reresolve:  time.Nanosecond,
+		TimeoutDur: 200 * time.Millisecond,
+		DelayDur:   10 * time.Millisecond,
+	}
+	r := &recordingReporter{}
+	a := NewApp(context.Background(), cfg, r)
+	if err := a.Run(); err != nil {
+		t.Fatalf("App.Run() error = %v, expected nil", err)
+	}
+
+	if len(r.events) != 1 {
+		t.Fatalf("expected one resolve event, got %+v", r.events)
+	}
+	ev := r.events[0]
+	if ev.Kind != models.EventResolve || len(ev.Added) != 1 || ev.Added[0].IP != "127.0.0.1" ||
+		len(ev.Removed) != 1 || ev.Removed[0].IP != "192.0.2.7" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	st := a.targets[0].stats
+	if !st["192.0.2.7"].Retired || st["192.0.2.7"].Attempts != 0 || st["127.0.0.1"].Attempts != 2 {
+		t.Errorf("unexpected stats %+v / %+v", st["192.0.2.7"], st["127.0.0.1"])
+	}
+	if len(cfg.IPs) != 2 {
+		t.Errorf("retired IPs should stay in the session, got %v", cfg.IPs)
+	}
+}
+
+func TestApp_Run_Wait(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	_, open, _ := net.SplitHostPort(ln.Addr().String())
+
+	target := func(port string) *models.Config {
+		return &models.Config{
+			Proto:      models.TCP,
+			Host:       "127.0.0.1",
+			Port:       port,
+			IPs:        []models.IP{{IP: "127.0.0.1", IsIPv4: true}},
+			TimeoutDur: 50 * time.Millisecond,
+		}
+	}
+	tests := []struct {
+		name     string
+		wait     models.WaitFor
+		ports    []string
+		count    int
+		deadline time.Duration
+		results  int
+		err      string
+	}{
+		{name: "up", wait: models.WaitUp, ports: []string{open}, results: 1},
+		{name: "down", wait: models.WaitDown, ports: []string{"1"}, results: 1},
+		{name: "up deadline", wait: models.WaitUp, ports: []string{"1"}, deadline: 100 * time.Millisecond, err: "deadline of 100ms expired"},
+		{name: "down count", wait: models.WaitDown, ports: []string{open}, count: 2, results: 2, err: "after 2 attempts"},
+		{name: "targets", wait: models.WaitUp, ports: []string{open, "1"}, count: 3, results: 4, err: "after 3 attempts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{
+				Count:      tt.count,
+				Nonstop:    tt.count == 0,
+				Wait:       tt.wait,
+				Deadline:   tt.deadline,
+				TimeoutDur: 50 * time.Millisecond,
+				DelayDur:   20 * time.Millisecond,
+			}
+			for _, port := range tt.ports {
+				cfg.Targets = append(cfg.Targets, target(port))
+			}
+			r := &recordingReporter{}
+			err := NewApp(context.Background(), cfg, r).Run()
+			if tt.err == "" && err != nil {
+				t.Fatalf("App.Run() error = %v, expected nil", err)
+			}
+			if tt.err != "" && (!errors.Is(err, ErrNotMet) || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("App.Run() error = %v, expected %q", err, tt.err)
+			}
+			if tt.results > 0 && len(r.results) != tt.results {
+				t.Errorf("got %d results, expected %d", len(r.results), tt.results)
+			}
+			if r.summaries != len(tt.ports) {
+				t.Errorf("got %d summaries, expected %d", r.summaries, len(tt.ports))
+			}
+		})
 	}
 }
 
+func TestApp_Run_StopConditions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	_, open, _ := net.SplitHostPort(ln.Addr().String())
+
+	tests := []struct {
+		name    string
+		port    string
+		cfg     models.Config
+		results int
+		stop    models.StopReason
+	}{
+		{name: "count", port: open, cfg: models.Config{Count: 2, Successes: 5}, results: 2, stop: models.StopCount},
+		{name: "successes", port: open, cfg: models.Config{Nonstop: true, Successes: 3}, results: 3, stop: models.StopSuccesses},
+		{name: "fail streak", port: "1", cfg: models.Config{Count: 10, FailStreak: 2}, results: 2, stop: models.StopFailures},
+		{name: "deadline", port: "1", cfg: models.Config{Nonstop: true, Deadline: 100 * time.Millisecond}, stop: models.StopDeadline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Proto = models.TCP
+			cfg.Host = "127.0.0.1"
+			cfg.Port = tt.port
+			cfg.IPs = []models.IP{{IP: "127.0.0.1", IsIPv4: true}}
+			cfg.TimeoutDur = 50 * time.Millisecond
+			cfg.DelayDur = 10 * time.Millisecond
+			r := &recordingReporter{}
+
+			started := time.Now()
+			if err := NewApp(context.Background(), &cfg, r).Run(); err != nil {
+				t.Fatalf("App.Run() error = %v, expected nil", err)
+			}
+			if tt.results > 0 && len(r.results) != tt.results {
+				t.Errorf("got %d results, expected %d", len(r.results), tt.results)
+			}
+			if cfg.Stop != tt.stop {
+				t.Errorf("Stop = %q, expected %q", cfg.Stop, tt.stop)
+			}
+			if cfg.Deadline > 0 && time.Since(started) > 3*cfg.Deadline {
+				t.Errorf("session took %v, expected about the deadline", time.Since(started))
+			}
+		})
+	}
+}
+
+func TestApp_Status(t *testing.T) {
+	cfg := &models.Config{
+		Proto:      models.TCP,
+		Host:       "127.0.0.1",
+		Port:       "1",
+		IPs:        []models.IP{{IP: "127.0.0.1", IsIPv4: true}},
+		Count:      2,
+		TimeoutDur: 50 * time.Millisecond,
+		DelayDur:   10 * time.Millisecond,
+	}
+	a := NewApp(context.Background(), cfg, &recordingReporter{})
+	if err := a.Run(); err != nil {
+		t.Fatalf("App.Run() error = %v", err)
+	}
+	if got := a.Status(); got != models.StatusOK {
+		t.Errorf("Status() without limits = %s, expected OK", got)
+	}
+
+	cfg.Limits.Loss = models.Limit{Warn: 10, Crit: 50, HasWarn: true, HasCrit: true}
+	if err := a.Run(); err != nil {
+		t.Fatalf("App.Run() error = %v", err)
+	}
+	if got := a.Status(); got != models.StatusCritical {
+		t.Errorf("Status() with 100%% loss = %s, expected CRITICAL", got)
+	}
+}
+
+func TestApp_Run_UDPStates(t *testing.T) {
+	reflector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = echo.ServeUDP(ctx, reflector, false) }()
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = silent.Close() }()
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+
+	port := func(c net.PacketConn) string {
+		return strconv.Itoa(c.LocalAddr().(*net.UDPAddr).Port)
+	}
+	tests := []struct {
+		name  string
+		port  string
+		state models.PortState
+		class models.ErrClass
+	}{
+		{"reply", port(reflector), models.StateOpen, models.ErrNone},
+		{"icmp unreachable", port(closed), models.StateClosed, models.ErrPortUnreachable},
+		{"no reply", port(silent), models.StateOpenFiltered, models.ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{
+				Proto:      models.UDP,
+				Port:       tt.port,
+				IPs:        []models.IP{{IP: "127.0.0.1", IsIPv4: true}},
+				UDPPayload: []byte("ping"),
+				Count:      1,
+				TimeoutDur: 200 * time.Millisecond,
+			}
+			r := &recordingReporter{}
+			a := NewApp(context.Background(), cfg, r)
+			if err := a.Run(); err != nil {
+				t.Fatalf("App.Run() error = %v", err)
+			}
+			if len(r.results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(r.results))
+			}
+			res := r.results[0]
+			if res.State != tt.state || res.ErrClass != tt.class {
+				t.Errorf("state %q class %q, expected %q and %q (err %v)", res.State, res.ErrClass, tt.state, tt.class, res.Err)
+			}
+			if st := a.targets[0].stats["127.0.0.1"]; st.States[tt.state] != 1 {
+				t.Errorf("summary states = %v", st.States)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"github.com/sopov/portping/internal/models"
+	"github.com/sopov/portping/internal/probe"
+	"net"
+	"time"
+)
+
+// active returns the IPs of t that are still in the DNS answer.
+func (t *target) active() []models.IP {
+	ips := make([]models.IP, 0, len(t.cfg.IPs))
+	for _, ip := range t.cfg.IPs {
+		if !t.stats[ip.IP].Retired {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// probes returns the number of probes of t in a round.
+func (t *target) probes() int {
+	n := len(t.active())
+	if t.cfg.HappyEyeballs {
+		n = min(n, 1)
+	}
+	return n
+}
+
+// update applies a fresh DNS answer to t: unknown IPs join the session
+// with fresh stats, known IPs missing from the answer are retired and
+// retired IPs that are back become active again.
+func (t *target) update(ips []models.IP) (added, removed []models.IP) {
+	current := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		current[ip.IP] = true
+		st, known := t.stats[ip.IP]
+		switch {
+		case !known:
+			t.cfg.IPs = append(t.cfg.IPs, ip)
+			t.stats[ip.IP] = &models.Stats{IP: ip}
+			added = append(added, ip)
+		case st.Retired:
+			st.Retired = false
+			added = append(added, ip)
+		}
+	}
+	for _, ip := range t.cfg.IPs {
+		if st := t.stats[ip.IP]; !current[ip.IP] && !st.Retired {
+			st.Retired = true
+			removed = append(removed, ip)
+		}
+	}
+	return added, removed
+}
+
+// reresolve looks up the targets again once their -reresolve interval has
+// passed and reports every change or failure as an event. A failed lookup
+// keeps the current IPs.
+func (a *App) reresolve(targets []*target) {
+	for _, t := range targets {
+		if t.cfg.Reresolve <= 0 || time.Since(t.resolved) < t.cfg.Reresolve {
+			continue
+		}
+		if net.ParseIP(t.cfg.Host) != nil {
+			continue
+		}
+		t.resolved = time.Now()
+		ips, err := probe.GetAddrs(t.cfg)
+		ev := models.Event{Kind: models.EventResolve, Time: t.resolved, Err: err}
+		if err == nil {
+			ev.Added, ev.Removed = t.update(ips)
+		}
+		if err != nil || len(ev.Added) > 0 || len(ev.Removed) > 0 {
+			a.report.OnEvent(t.cfg, ev)
+		}
+	}
+}
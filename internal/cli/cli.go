@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"flag"
@@ -12,17 +13,44 @@ import (
 	"github.com/sopov/portping/internal/models"
 	"github.com/sopov/portping/internal/probe"
 	"net"
+	"net/http"
 	"os"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var cfgFlags struct {
-	udp bool
-	tcp bool
-	v4  bool
-	v6  bool
+	udp         bool
+	tcp         bool
+	proto       string
+	v4          bool
+	v6          bool
+	dual        bool
+	wait        bool
+	waitDown    bool
+	maxLoss     string
+	maxAvg      string
+	maxP95      string
+	nagios      bool
+	output      string
+	httpHeaders headerList
+	httpStatus  string
+	httpBody    string
+	expect      string
+	targetsFile string
+}
+
+// headerList collects repeated -http-header flags.
+type headerList []string
+
+func (h *headerList) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerList) Set(v string) error {
+	*h = append(*h, v)
+	return nil
 }
 
 func Parse() (*models.Config, error) {
@@ -30,6 +58,15 @@ func Parse() (*models.Config, error) {
 }
 
 func parseWith(fs *flag.FlagSet, args []string) (*models.Config, error) {
+	cfg, err := parseOptions(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, parseDestinations(fs, cfg)
+}
+
+// parseOptions parses the flags of fs into a config without destination.
+func parseOptions(fs *flag.FlagSet, args []string) (*models.Config, error) {
 	cfg := &models.Config{}
 	initFlags(fs, cfg)
 	if err := fs.Parse(args); err != nil {
@@ -44,42 +81,132 @@ func parseWith(fs *flag.FlagSet, args []string) (*models.Config, error) {
 	} else if cfgFlags.tcp {
 		cfg.Proto = models.TCP
 	}
+	if cfgFlags.proto != "" {
+		proto := models.Proto(strings.ToLower(cfgFlags.proto))
+		switch proto {
+		case models.TCP, models.UDP, models.HTTP:
+		default:
+			return nil, fmt.Errorf("invalid proto `%s`", cfgFlags.proto)
+		}
+		if cfg.Proto != "" && cfg.Proto != proto {
+			return nil, fmt.Errorf("conflicting -proto %s and -%s", proto, cfg.Proto)
+		}
+		cfg.Proto = proto
+	}
 	// ipv4/ipv6
-	if cfgFlags.v4 || cfgFlags.v6 {
+	if cfgFlags.dual {
+		cfg.Dual = true
+		cfg.AllowIPv4 = true
+		cfg.AllowIPv6 = true
+	} else if cfgFlags.v4 || cfgFlags.v6 {
 		cfg.AllowIPv4 = cfgFlags.v4
 		cfg.AllowIPv6 = cfgFlags.v6
 	} else {
 		cfg.AllowIPv4 = true
+		// Happy Eyeballs is about racing both families
+		cfg.AllowIPv6 = cfg.HappyEyeballs
 	}
-
-	if err := parseArgs(fs, cfg); err != nil {
-		return nil, err
+	if cfg.Source != "" {
+		ip := net.ParseIP(cfg.Source)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address `%s`", cfg.Source)
+		}
+		if cfg.Dual {
+			return nil, fmt.Errorf("-46 cannot be combined with -source")
+		}
+		// the source address decides the family of the destinations
+		v4 := ip.To4() != nil
+		if v4 && cfgFlags.v6 && !cfgFlags.v4 || !v4 && cfgFlags.v4 && !cfgFlags.v6 {
+			return nil, fmt.Errorf("source address `%s` does not match the address family of -4/-6", cfg.Source)
+		}
+		cfg.AllowIPv4, cfg.AllowIPv6 = v4, !v4
 	}
 
-	if cfg.Proto != models.UDP && cfg.Proto != models.TCP {
-		cfg.Proto = models.TCP
+	if cfg.TWAMP && cfg.Preset == "" {
+		cfg.Preset = "echo"
 	}
 
-	if cfg.Proto == models.UDP && cfg.UDPPayloadHex != "" {
-		b, err := hex.DecodeString(cfg.UDPPayloadHex)
-		if err != nil {
-			return nil, errors.New("invalid UDP payload, should be hex string")
-		}
-		cfg.UDPPayload = b
+	// wait-for
+	if cfgFlags.wait && cfgFlags.waitDown {
+		return nil, fmt.Errorf("both -wait and -wait-down are set")
+	}
+	if cfgFlags.wait {
+		cfg.Wait = models.WaitUp
+	} else if cfgFlags.waitDown {
+		cfg.Wait = models.WaitDown
 	}
 
-	if cfg.Proto != models.UDP {
-		cfg.UDPPayloadHex = ""
-		cfg.UDPPayload = nil
+	// thresholds
+	var err error
+	if cfg.Limits.Loss, err = parseLimit("max-loss", cfgFlags.maxLoss, parsePercent); err != nil {
+		return nil, err
+	}
+	if cfg.Limits.Avg, err = parseLimit("max-avg", cfgFlags.maxAvg, parseMs); err != nil {
+		return nil, err
+	}
+	if cfg.Limits.P95, err = parseLimit("max-p95", cfgFlags.maxP95, parseMs); err != nil {
+		return nil, err
 	}
 
 	cfg.TimeoutDur = time.Duration(cfg.Timeout) * time.Millisecond
 	cfg.DelayDur = time.Duration(cfg.Delay) * time.Millisecond
 	cfg.Nonstop = cfg.Count == 0 // boolean flag for nonstop mode
+	cfg.Outputs = parseOutputs(cfgFlags.output)
+	if cfgFlags.nagios {
+		// the status line owns stdout, other outputs go to files
+		outputs := []models.OutputSpec{{Format: models.OutputNagios}}
+		for _, o := range cfg.Outputs {
+			if o.Path != "" {
+				outputs = append(outputs, o)
+			}
+		}
+		cfg.Outputs = outputs
+	}
 
-	colors.NoColor(cfg.NoColor)
+	// reporters pick their own colors, this covers the messages on stderr
+	if cfg.NoColor {
+		colors.NoColor(true)
+	}
 
-	return cfg, Validate(cfg)
+	if cfg.Resolver != "" {
+		addr, tcp, err := parseResolver(cfg.Resolver)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Resolver, cfg.ResolverTCP = addr, tcp
+	}
+	return cfg, nil
+}
+
+// parseDestinations fills the destination of cfg, or its targets, from the
+// arguments left in fs and validates the result.
+func parseDestinations(fs *flag.FlagSet, cfg *models.Config) error {
+	specs, err := targetSpecs(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(specs) > 0 {
+		if err := parsePresetFlags(fs, cfg); err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			t, err := parseTarget(cfg, spec.addr, spec.port)
+			if err != nil {
+				return err
+			}
+			cfg.Targets = append(cfg.Targets, t)
+		}
+		return Validate(cfg)
+	}
+
+	if err := parseArgs(fs, cfg); err != nil {
+		return err
+	}
+	if err := completeTarget(cfg); err != nil {
+		return err
+	}
+
+	return Validate(cfg)
 }
 
 func initFlags(fs *flag.FlagSet, cfg *models.Config) {
@@ -91,16 +218,60 @@ func initFlags(fs *flag.FlagSet, cfg *models.Config) {
 
 	fs.IntVar(&cfg.Timeout, "t", 1000, "Timeout in milliseconds")
 	fs.IntVar(&cfg.Delay, "d", 1000, "Delay in milliseconds")
-	fs.IntVar(&cfg.Count, "c", 0, "Stop after connecting count times")
+	fs.IntVar(&cfg.Count, "c", 0, "Stop after `count` attempts, successful or not")
+	fs.IntVar(&cfg.Successes, "successes", 0, "Stop after `n` successful attempts")
+	fs.IntVar(&cfg.FailStreak, "fail-streak", 0, "Stop after `n` consecutive failed attempts")
+	fs.BoolVar(&cfgFlags.wait, "wait", false, "Wait until the destination is reachable: exit 0 after the first success")
+	fs.BoolVar(&cfgFlags.waitDown, "wait-down", false, "Wait until the destination is unreachable: exit 0 after the first failure")
+	fs.DurationVar(&cfg.Deadline, "deadline", 0, "Stop the whole session after `duration` (e.g. 60s); with -wait the exit code is 3")
+	fs.DurationVar(&cfg.Deadline, "w", 0, "Same as -deadline")
+	fs.StringVar(&cfgFlags.maxLoss, "max-loss", "", "Loss limit `[warn,]crit` in percent (e.g. 1%,5%); exceeding it sets the exit code")
+	fs.StringVar(&cfgFlags.maxAvg, "max-avg", "", "Average RTT limit `[warn,]crit` (e.g. 50ms,100ms)")
+	fs.StringVar(&cfgFlags.maxP95, "max-p95", "", "95th percentile RTT limit `[warn,]crit` (e.g. 100ms,200ms)")
+	fs.DurationVar(&cfg.Reresolve, "reresolve", 0, "Repeat the DNS lookup every `interval` (e.g. 30s) and follow IP changes")
+	fs.StringVar(&cfg.Resolver, "resolver", "", "DNS server `[tcp://]host[:port]` to resolve destinations with (default: system resolver)")
+	fs.StringVar(&cfg.Source, "source", "", "Send probes from source `ip` (e.g. on multi-homed hosts)")
+	fs.IntVar(&cfg.SourcePort, "sport", 0, "Send probes from source `port` (default: ephemeral)")
+	fs.StringVar(&cfg.Interface, "interface", "", "Bind probes to network interface `name` (SO_BINDTODEVICE, Linux only)")
+	fs.IntVar(&cfg.Workers, "workers", 100, "Maximum number of concurrent probes in port sweeps and -parallel rounds")
+	fs.BoolVar(&cfg.Parallel, "parallel", false, "Probe all IPs and targets of a round concurrently")
+	fs.BoolVar(&cfg.HappyEyeballs, "happy", false, "Race all IPs in every attempt like a Happy Eyeballs (RFC 8305) client (TCP only)")
+	fs.BoolVar(&cfg.TWAMP, "twamp", false, "Two-way session with a portping listen -timestamp reflector: loss per direction, reordering, duplicates, jitter (implies -echo)")
 
 	fs.BoolVar(&cfgFlags.v4, "4", false, "Allow IPv4 (default)")
 	fs.BoolVar(&cfgFlags.v6, "6", false, "Allow IPv6")
+	fs.BoolVar(&cfgFlags.dual, "46", false, "Dual-stack: probe IPv4 and IPv6 and compare them")
+	fs.BoolVar(&cfgFlags.dual, "dual", false, "Same as -46")
 
 	fs.StringVar(&cfg.Preset, "preset", "", "Preset name: "+presetsHelp())
 	fs.StringVar(&cfg.UDPPayloadHex, "payload", "", "UDP Payload in hex string format")
 
 	fs.BoolVar(&cfgFlags.udp, "udp", false, "UDP Ping")
 	fs.BoolVar(&cfgFlags.tcp, "tcp", false, "TCP Ping (default)")
+	fs.StringVar(&cfgFlags.proto, "proto", "", "Protocol: tcp, udp or http (HTTP request probe)")
+
+	fs.StringVar(&cfg.HTTP.Method, "http-method", http.MethodGet, "HTTP request method")
+	fs.StringVar(&cfg.HTTP.Path, "http-path", "/", "HTTP request path")
+	cfgFlags.httpHeaders = nil
+	fs.Var(&cfgFlags.httpHeaders, "http-header", "HTTP request header `Name: value`, repeatable")
+	fs.StringVar(&cfgFlags.httpStatus, "http-status", "200-399", "Expected HTTP status codes, e.g. `200,204,3xx`")
+	fs.StringVar(&cfgFlags.httpBody, "http-body", "", "Fail unless the HTTP response body matches `regex`")
+
+	fs.BoolVar(&cfg.TLS, "tls", false, "Perform a TLS handshake after connecting (TCP only)")
+	fs.BoolVar(&cfg.TLSInsecure, "insecure", false, "Skip TLS certificate verification")
+	fs.StringVar(&cfg.TLSCAFile, "cacert", "", "PEM `file` with CA certificates to verify TLS against")
+	fs.IntVar(&cfg.CertWarnDays, "cert-warn", 0, "Warn when the TLS certificate expires within `days`")
+
+	fs.BoolVar(&cfg.Banner, "banner", false, "Read the service banner after connecting (TCP only)")
+	fs.StringVar(&cfgFlags.expect, "expect", "", "Fail unless the banner matches `regex` (implies -banner)")
+
+	fs.BoolVar(&cfg.Verbose, "v", false, "Verbose output")
+
+	fs.StringVar(&cfgFlags.targetsFile, "f", "", "Read targets from `file`, one `[scheme://]host[:port]` or `host port` per line")
+
+	fs.StringVar(&cfgFlags.output, "o", models.OutputText.String(),
+		"Comma-separated outputs `format[:file]`, format is text, json, ndjson, csv or nagios")
+	fs.BoolVar(&cfgFlags.nagios, "nagios", false, "Print a Nagios/Icinga plugin status line and exit 0/1/2/3 by the -max-* limits (at least one is required)")
 
 	names := make([]string, 0, len(probe.Predefined))
 	for n := range probe.Predefined {
@@ -109,7 +280,11 @@ func initFlags(fs *flag.FlagSet, cfg *models.Config) {
 	sort.Strings(names)
 	for _, name := range names {
 		pr := probe.Predefined[name]
-		usage := fmt.Sprintf("Use preset `%s` (%s, port %s)", strings.ToUpper(name), pr.Proto.String(), pr.Port)
+		proto := pr.Proto.String()
+		if pr.TLS {
+			proto += "+tls"
+		}
+		usage := fmt.Sprintf("Use preset `%s` (%s, port %s)", strings.ToUpper(name), proto, pr.Port)
 		fs.Bool(name, false, usage) // дефолт и так false, лишний SetDefValue не нужен
 	}
 }
@@ -121,27 +296,8 @@ func parseArgs(fs *flag.FlagSet, cfg *models.Config) error {
 	}
 	host, port, payloadFromArg, portInHost := "", "", "", false
 
-	var chosen string
-	var count int
-	names := make([]string, 0, len(probe.Predefined))
-	for n := range probe.Predefined {
-		names = append(names, n)
-	}
-	sort.Strings(names)
-	for _, name := range names {
-		if f := fs.Lookup(name); f != nil && f.Value.String() == "true" {
-			chosen = name
-			count++
-			if count > 1 {
-				return fmt.Errorf("multiple presets selected")
-			}
-		}
-	}
-	if chosen != "" && cfg.Preset == "" {
-		cfg.Preset = chosen
-	}
-	if chosen != "" && cfg.Preset != "" && cfg.Preset != chosen {
-		return fmt.Errorf("conflicting presets: %q and %q", cfg.Preset, chosen)
+	if err := parsePresetFlags(fs, cfg); err != nil {
+		return err
 	}
 
 	raw0 := args[0]
@@ -160,23 +316,15 @@ func parseArgs(fs *flag.FlagSet, cfg *models.Config) error {
 		}
 	}
 
-	// resolve boolean shortcuts preset
+	cfg.Host = host
+	cfg.Port = port
+	if port == "" && cfg.Proto == "" {
+		cfg.Proto = srvProto(host)
+	}
 
 	if cfg.Preset != "" {
-		pr, ok := probe.GetPreset(cfg.Preset)
-		if !ok {
-			return fmt.Errorf("invalid preset %q", cfg.Preset)
-		}
-		if port == "" {
-			port = pr.Port
-		}
-
-		if cfg.Proto != models.UDP && cfg.Proto != models.TCP {
-			cfg.Proto = pr.Proto
-		}
-
-		if cfg.Proto == models.UDP && cfg.UDPPayloadHex == "" {
-			cfg.UDPPayloadHex = pr.UDPPayloadHex
+		if err := applyPreset(cfg); err != nil {
+			return err
 		}
 	}
 
@@ -198,12 +346,231 @@ func parseArgs(fs *flag.FlagSet, cfg *models.Config) error {
 		cfg.UDPPayloadHex = payloadFromArg
 	}
 
-	cfg.Host = host
-	cfg.Port = port
+	return nil
+}
+
+// parsePresetFlags resolves the boolean preset shortcuts like -dns.
+func parsePresetFlags(fs *flag.FlagSet, cfg *models.Config) error {
+	var chosen string
+	var count int
+	names := make([]string, 0, len(probe.Predefined))
+	for n := range probe.Predefined {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if f := fs.Lookup(name); f != nil && f.Value.String() == "true" {
+			chosen = name
+			count++
+			if count > 1 {
+				return fmt.Errorf("multiple presets selected")
+			}
+		}
+	}
+	if chosen != "" && cfg.Preset == "" {
+		cfg.Preset = chosen
+	}
+	if chosen != "" && cfg.Preset != "" && cfg.Preset != chosen {
+		return fmt.Errorf("conflicting presets: %q and %q", cfg.Preset, chosen)
+	}
+	return nil
+}
+
+// applyPreset fills the port, protocol, payload and TLS defaults of
+// cfg.Preset that were not set explicitly.
+func applyPreset(cfg *models.Config) error {
+	pr, ok := probe.GetPreset(cfg.Preset)
+	if !ok {
+		return fmt.Errorf("invalid preset %q", cfg.Preset)
+	}
+	if cfg.Port == "" && !isSRV(cfg) {
+		cfg.Port = pr.Port
+	}
+
+	if cfg.Proto == "" {
+		cfg.Proto = pr.Proto
+	}
+
+	if cfg.Proto == models.UDP && cfg.UDPPayloadHex == "" {
+		cfg.UDPPayloadHex = pr.UDPPayloadHex
+	}
+
+	if pr.TLS && cfg.Proto != models.UDP {
+		cfg.TLS = true
+	}
+	return nil
+}
+
+// completeTarget applies the protocol dependent settings once the
+// destination, protocol and preset of cfg are known.
+func completeTarget(cfg *models.Config) error {
+	if cfg.Proto == "" {
+		cfg.Proto = models.TCP
+	}
+
+	if cfg.IsHTTP() {
+		if err := parseHTTPCheck(cfg); err != nil {
+			return err
+		}
+	}
+
+	if err := parseExpect(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Proto == models.UDP && cfg.UDPPayloadHex != "" {
+		b, err := hex.DecodeString(cfg.UDPPayloadHex)
+		if err != nil {
+			return errors.New("invalid UDP payload, should be hex string")
+		}
+		cfg.UDPPayload = b
+	}
+
+	if cfg.Proto != models.UDP {
+		cfg.UDPPayloadHex = ""
+		cfg.UDPPayload = nil
+	}
+	return nil
+}
+
+// parseExpect compiles the banner pattern from -expect, falling back to the
+// preset default when only -banner is given.
+func parseExpect(cfg *models.Config) error {
+	pattern := cfgFlags.expect
+	if pattern != "" {
+		cfg.Banner = true
+	}
+	if !cfg.Banner {
+		return nil
+	}
+	if pattern == "" && cfg.Preset != "" {
+		pattern = probe.Predefined[cfg.Preset].Expect
+	}
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid expect regex: %w", err)
+	}
+	cfg.Expect = re
+	return nil
+}
+
+// parseHTTPCheck completes cfg.HTTP from the -http-* flags.
+func parseHTTPCheck(cfg *models.Config) error {
+	check := &cfg.HTTP
+	check.Method = strings.ToUpper(check.Method)
+	check.Headers = make(http.Header)
+	if !strings.HasPrefix(check.Path, "/") {
+		check.Path = "/" + check.Path
+	}
+
+	for _, h := range cfgFlags.httpHeaders {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid HTTP header `%s`, expected `Name: value`", h)
+		}
+		check.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	status, err := parseStatusRanges(cfgFlags.httpStatus)
+	if err != nil {
+		return err
+	}
+	check.Status = status
 
+	if cfgFlags.httpBody != "" {
+		re, err := regexp.Compile(cfgFlags.httpBody)
+		if err != nil {
+			return fmt.Errorf("invalid HTTP body regex: %w", err)
+		}
+		check.Body = re
+	}
 	return nil
 }
 
+// parseStatusRanges parses "200,204,300-399,4xx" into status ranges.
+func parseStatusRanges(s string) ([]models.StatusRange, error) {
+	var ranges []models.StatusRange
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		var r models.StatusRange
+		var err1, err2 error
+		switch {
+		case len(item) == 3 && strings.HasSuffix(item, "xx"):
+			r.Min, err1 = strconv.Atoi(item[:1] + "00")
+			r.Max = r.Min + 99
+		case strings.Contains(item, "-"):
+			lo, hi, _ := strings.Cut(item, "-")
+			r.Min, err1 = strconv.Atoi(lo)
+			r.Max, err2 = strconv.Atoi(hi)
+		default:
+			r.Min, err1 = strconv.Atoi(item)
+			r.Max = r.Min
+		}
+		if err1 != nil || err2 != nil || r.Min < 100 || r.Max > 599 || r.Min > r.Max {
+			return nil, fmt.Errorf("invalid HTTP status `%s`", item)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// parseOutputs splits "text,ndjson:run.ndjson" into output specs.
+func parseOutputs(s string) []models.OutputSpec {
+	var outputs []models.OutputSpec
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		format, path, _ := strings.Cut(item, ":")
+		outputs = append(outputs, models.OutputSpec{
+			Format: models.Output(strings.ToLower(format)),
+			Path:   path,
+		})
+	}
+	if len(outputs) == 0 {
+		outputs = append(outputs, models.OutputSpec{Format: models.OutputText})
+	}
+	return outputs
+}
+
+// NagiosRequested reports whether args ask for Nagios output through
+// -nagios or a nagios item of -o. It only looks at the raw arguments, so it
+// also answers when parsing them fails.
+func NagiosRequested(args []string) bool {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "nagios":
+			if on, err := strconv.ParseBool(value); !hasValue || err == nil && on {
+				return true
+			}
+		case "o":
+			if !hasValue && i+1 < len(args) {
+				value = args[i+1]
+			}
+			for _, o := range parseOutputs(value) {
+				if o.Format == models.OutputNagios {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // trySplitHostPort handles cases like "::1:80" -> "[::1]:80"
 func trySplitHostPort(s string) (host, port string, err error) {
 	// Normal forms: "host:port", "[v6]:port"
@@ -225,13 +592,110 @@ func trySplitHostPort(s string) (host, port string, err error) {
 	return "", "", fmt.Errorf("invalid address `%s`", s)
 }
 
+// parseLimit parses a "[warn,]crit" threshold flag; a single value is the
+// critical threshold.
+func parseLimit(name, s string, parse func(string) (float64, error)) (models.Limit, error) {
+	var l models.Limit
+	if s == "" {
+		return l, nil
+	}
+	warn, crit, both := strings.Cut(s, ",")
+	if !both {
+		warn, crit = "", warn
+	}
+	var err error
+	if l.Crit, err = parse(strings.TrimSpace(crit)); err != nil {
+		return l, fmt.Errorf("invalid -%s `%s`: %w", name, s, err)
+	}
+	l.HasCrit = true
+	if both {
+		if l.Warn, err = parse(strings.TrimSpace(warn)); err != nil {
+			return l, fmt.Errorf("invalid -%s `%s`: %w", name, s, err)
+		}
+		if l.Warn > l.Crit {
+			return l, fmt.Errorf("invalid -%s `%s`: warning is above critical", name, s)
+		}
+		l.HasWarn = true
+	}
+	return l, nil
+}
+
+// parsePercent parses a percentage like "5%" or "2.5".
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0, errors.New("expected a percentage from 0 to 100")
+	}
+	return v, nil
+}
+
+// parseMs parses a duration like "50ms" into milliseconds; a bare number is
+// taken as milliseconds like -t and -d.
+func parseMs(s string) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil && v >= 0 {
+		return v, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("expected a duration like 50ms")
+	}
+	return helpers.Ms2Float64(d), nil
+}
+
+// parseResolver parses a -resolver address. The port defaults to 53 and a
+// "tcp://" prefix makes every query use TCP; "udp://" is the default, which
+// still falls back to TCP for truncated answers.
+func parseResolver(s string) (addr string, tcp bool, err error) {
+	rest := s
+	if scheme, r, ok := strings.Cut(s, "://"); ok {
+		switch models.Proto(strings.ToLower(scheme)) {
+		case models.TCP:
+			tcp = true
+		case models.UDP:
+		default:
+			return "", false, fmt.Errorf("invalid resolver scheme `%s`", scheme)
+		}
+		rest = r
+	}
+	host, port, err := trySplitHostPort(rest)
+	if ip := net.ParseIP(strings.Trim(rest, "[]")); err != nil || ip != nil {
+		host, port = strings.Trim(rest, "[]"), "53"
+	}
+	if host == "" || strings.ContainsAny(host, "/[]") || !helpers.ValidPort(port) {
+		return "", false, fmt.Errorf("invalid resolver `%s`", s)
+	}
+	return net.JoinHostPort(host, port), tcp, nil
+}
+
 func Validate(cfg *models.Config) error {
-	if len(cfg.Host) < 1 || len(cfg.Port) < 1 {
-		return fmt.Errorf("host or port is required")
+	if len(cfg.Targets) == 0 && !isSRV(cfg) {
+		if err := validateDestination(cfg); err != nil {
+			return err
+		}
 	}
-	if !helpers.ValidPort(cfg.Port) {
-		return fmt.Errorf("invalid port `%s`", cfg.Port)
+	if err := validateOptions(cfg); err != nil {
+		return err
 	}
+	if err := expandSRV(cfg); err != nil {
+		return err
+	}
+	if len(cfg.Targets) == 0 {
+		return resolve(cfg)
+	}
+	for _, t := range cfg.Targets {
+		t.TLSRootCAs = cfg.TLSRootCAs
+		if err := validateDestination(t); err != nil {
+			return fmt.Errorf("target `%s`: %w", t.Name(), err)
+		}
+		if err := resolve(t); err != nil {
+			return fmt.Errorf("target `%s`: %w", t.Name(), err)
+		}
+	}
+	return nil
+}
+
+// validateOptions checks the session part of the config.
+func validateOptions(cfg *models.Config) error {
 	if cfg.Timeout < 1 {
 		return fmt.Errorf("timeout must be greater than 0")
 	}
@@ -241,18 +705,146 @@ func Validate(cfg *models.Config) error {
 	if cfg.Count < 0 {
 		return fmt.Errorf("count must be greater than or equal to 0")
 	}
+	if cfg.Successes < 0 {
+		return fmt.Errorf("successes must be greater than or equal to 0")
+	}
+	if cfg.FailStreak < 0 {
+		return fmt.Errorf("fail-streak must be greater than or equal to 0")
+	}
+	if cfg.Reresolve < 0 {
+		return fmt.Errorf("reresolve interval must be greater than or equal to 0")
+	}
+	if cfg.Deadline < 0 {
+		return fmt.Errorf("deadline must be greater than or equal to 0")
+	}
+	if cfg.HappyEyeballs && cfg.Parallel {
+		return fmt.Errorf("-happy cannot be combined with -parallel")
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be greater than 0")
+	}
+	if cfg.SourcePort < 0 || cfg.SourcePort > 65535 {
+		return fmt.Errorf("invalid source port `%d`", cfg.SourcePort)
+	}
+	if cfg.Interface != "" {
+		if !probe.BindToDeviceSupported {
+			return fmt.Errorf("-interface is only supported on Linux")
+		}
+		if _, err := net.InterfaceByName(cfg.Interface); err != nil {
+			return fmt.Errorf("unknown interface `%s`", cfg.Interface)
+		}
+	}
+	if cfg.CertWarnDays < 0 {
+		return fmt.Errorf("cert-warn must be greater than or equal to 0")
+	}
+	if cfg.TLSCAFile != "" {
+		pool, err := loadCAFile(cfg.TLSCAFile)
+		if err != nil {
+			return err
+		}
+		cfg.TLSRootCAs = pool
+	}
+	stdout := 0
+	for _, o := range cfg.Outputs {
+		switch o.Format {
+		case models.OutputText, models.OutputJSON, models.OutputNDJSON, models.OutputCSV, models.OutputNagios:
+		default:
+			return fmt.Errorf("invalid output format `%s`", o.Format)
+		}
+		if o.Path == "" {
+			stdout++
+		}
+		// without limits every session would be OK, even a dead service
+		if o.Format == models.OutputNagios && !cfg.Limits.IsSet() {
+			return fmt.Errorf("nagios output requires at least one -max-loss, -max-avg or -max-p95 limit")
+		}
+	}
+	if stdout > 1 {
+		return fmt.Errorf("only one output can be written to stdout")
+	}
+	return nil
+}
+
+// validateDestination checks the per-target part of the config.
+func validateDestination(cfg *models.Config) error {
+	if len(cfg.Host) < 1 || len(cfg.Port) < 1 {
+		return fmt.Errorf("host or port is required")
+	}
+	if strings.ContainsAny(cfg.Port, ",-") {
+		ports, err := helpers.ParsePorts(cfg.Port)
+		if err != nil {
+			return err
+		}
+		if len(ports) > 1 {
+			cfg.Ports = ports
+		} else {
+			cfg.Port = strconv.Itoa(ports[0])
+		}
+	} else if !helpers.ValidPort(cfg.Port) {
+		return fmt.Errorf("invalid port `%s`", cfg.Port)
+	}
 	if cfg.IsUDP() && cfg.UDPPayloadHex == "" && len(cfg.UDPPayload) == 0 {
 		return fmt.Errorf("UDP payload is required for UDP ping")
 	}
-	ips, err := probe.GetAddrs(cfg)
+	if cfg.TLS && cfg.IsUDP() {
+		return fmt.Errorf("TLS is not supported for UDP ping")
+	}
+	if cfg.Banner && !cfg.IsTCP() {
+		return fmt.Errorf("banner check is only supported for TCP ping")
+	}
+	if cfg.Wait != "" && cfg.IsSweep() {
+		return fmt.Errorf("-wait and -wait-down are not supported for port sweeps")
+	}
+	if cfg.HappyEyeballs && (!cfg.IsTCP() || cfg.TLS || cfg.Banner || cfg.IsSweep()) {
+		return fmt.Errorf("-happy only supports plain TCP connects to a single port")
+	}
+	if cfg.TWAMP && (cfg.Preset != "echo" || cfg.IsSweep()) {
+		return fmt.Errorf("-twamp only supports the echo preset on a single port")
+	}
+	if src, dst := net.ParseIP(cfg.Source), net.ParseIP(cfg.Host); src != nil && dst != nil && (src.To4() != nil) != (dst.To4() != nil) {
+		return fmt.Errorf("source address `%s` and destination `%s` are of different address families", cfg.Source, cfg.Host)
+	}
+	return nil
+}
+
+// resolve looks up the addresses of the destination.
+func resolve(cfg *models.Config) error {
+	ips, res, err := probe.Resolve(cfg)
 	if err != nil {
 		return err
 	}
 	cfg.IPs = ips
+	cfg.Resolution = res
 	SortIPs(cfg)
+	if cfg.Dual {
+		var v4, v6 bool
+		for _, ip := range ips {
+			v4 = v4 || ip.IsIPv4
+			v6 = v6 || ip.IsIPv6()
+		}
+		if !v4 || !v6 {
+			missing := "IPv4"
+			if v4 {
+				missing = "IPv6"
+			}
+			return fmt.Errorf("dual-stack mode requires both families, `%s` has no %s address", cfg.Host, missing)
+		}
+	}
 	return nil
 }
 
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path) // #nosec G304 -- path is supplied by the user
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in `%s`", path)
+	}
+	return pool, nil
+}
+
 func SortIPs(cfg *models.Config) {
 	sort.Slice(cfg.IPs, func(i, j int) bool {
 		ipA, ipB := cfg.IPs[i], cfg.IPs[j]
@@ -280,6 +872,10 @@ func Usage() string {
 	usage := strings.Join(
 		[]string{
 			"%s [options] <destination> <port> [UDP HEX PAYLOAD (UDP only)]",
+			"%[1]s [options] [scheme://]host:port... | -f <file>",
+			"%[1]s [options] _service._tcp|_udp.name (SRV)",
+			"%[1]s serve [-listen :9115] [options] [targets...] (Prometheus exporter)",
+			"%[1]s listen [-udp :port] [-tcp :port] [-timestamp] (echo reflector)",
 			"",
 			"Options:",
 			"%s",    // Usage Args
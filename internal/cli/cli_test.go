@@ -3,9 +3,11 @@ package cli
 import (
 	"flag"
 	"github.com/sopov/portping/internal/app"
+	"github.com/sopov/portping/internal/dnstest"
 	"github.com/sopov/portping/internal/models"
 	"github.com/sopov/portping/internal/probe"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -672,3 +674,754 @@ func TestSortIPs_SingleIP(t *testing.T) {
 		t.Errorf("Expected IP '192.168.1.1', got '%s'", cfg.IPs[0].IP)
 	}
 }
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []models.OutputSpec
+	}{
+		{"Empty", "", []models.OutputSpec{{Format: models.OutputText}}},
+		{"Single", "json", []models.OutputSpec{{Format: models.OutputJSON}}},
+		{"Text and file", "text,ndjson:run.ndjson", []models.OutputSpec{
+			{Format: models.OutputText},
+			{Format: models.OutputNDJSON, Path: "run.ndjson"},
+		}},
+		{"Windows path", "csv:C:\\tmp\\run.csv", []models.OutputSpec{{Format: models.OutputCSV, Path: "C:\\tmp\\run.csv"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseOutputs(tt.value)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("parseOutputs(%q) = %v, expected %v", tt.value, got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("parseOutputs(%q)[%d] = %v, expected %v", tt.value, i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidate_InvalidOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs []models.OutputSpec
+	}{
+		{"Unknown format", []models.OutputSpec{{Format: "xml"}}},
+		{"Two stdout outputs", []models.OutputSpec{{Format: models.OutputText}, {Format: models.OutputJSON}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{
+				Host:    "127.0.0.1",
+				Port:    "80",
+				Timeout: 1000,
+				Delay:   1000,
+				Outputs: tt.outputs,
+			}
+			if err := Validate(cfg); err == nil {
+				t.Error("Expected error for invalid outputs, got nil")
+			}
+		})
+	}
+}
+
+func TestParseArgs_HTTPSPresetEnablesTLS(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := &models.Config{}
+	initFlags(fs, cfg)
+
+	if err := fs.Parse([]string{"-https", "example.com"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if err := parseArgs(fs, cfg); err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+
+	if !cfg.TLS {
+		t.Error("expected TLS to be enabled by the https preset")
+	}
+	if cfg.Port != "443" {
+		t.Errorf("expected port 443 from https preset, got %q", cfg.Port)
+	}
+}
+
+func TestValidate_TLS(t *testing.T) {
+	cfg := &models.Config{
+		Proto:         models.UDP,
+		Host:          "127.0.0.1",
+		Port:          "443",
+		Timeout:       1000,
+		Delay:         1000,
+		UDPPayloadHex: "00",
+		TLS:           true,
+	}
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for TLS over UDP, got nil")
+	}
+
+	cfg.Proto = models.TCP
+	cfg.TLSCAFile = "does-not-exist.pem"
+	if err := Validate(cfg); err == nil {
+		t.Error("Expected error for missing CA file, got nil")
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []models.StatusRange
+		wantErr  bool
+	}{
+		{"200", []models.StatusRange{{Min: 200, Max: 200}}, false},
+		{"200,204", []models.StatusRange{{Min: 200, Max: 200}, {Min: 204, Max: 204}}, false},
+		{"200-399", []models.StatusRange{{Min: 200, Max: 399}}, false},
+		{"2xx,3XX", []models.StatusRange{{Min: 200, Max: 299}, {Min: 300, Max: 399}}, false},
+		{"abc", nil, true},
+		{"399-200", nil, true},
+		{"700", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseStatusRanges(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStatusRanges(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("parseStatusRanges(%q) = %v, expected %v", tt.value, got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("parseStatusRanges(%q)[%d] = %v, expected %v", tt.value, i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParse_HTTPProto(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-proto", "http", "-https", "-http-path", "health",
+		"-http-header", "X-Probe: portping", "-http-status", "2xx", "127.0.0.1"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cfg.IsHTTP() || !cfg.TLS {
+		t.Errorf("expected HTTP proto over TLS, got proto=%s tls=%v", cfg.Proto, cfg.TLS)
+	}
+	if cfg.Port != "443" {
+		t.Errorf("expected port 443 from https preset, got %q", cfg.Port)
+	}
+	if cfg.URL() != "https://127.0.0.1/health" {
+		t.Errorf("unexpected URL %q", cfg.URL())
+	}
+	if cfg.HTTP.Headers.Get("X-Probe") != "portping" {
+		t.Errorf("expected X-Probe header, got %v", cfg.HTTP.Headers)
+	}
+	if !cfg.HTTP.StatusOK(204) || cfg.HTTP.StatusOK(301) {
+		t.Errorf("unexpected status ranges %v", cfg.HTTP.Status)
+	}
+}
+
+func TestParse_ConflictingProto(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-udp", "-proto", "http", "127.0.0.1", "80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	if _, err := Parse(); err == nil {
+		t.Error("expected error for conflicting -udp and -proto http")
+	}
+}
+
+func TestParse_BannerPresetDefault(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-ssh", "-banner", "127.0.0.1"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Expect == nil || cfg.Expect.String() != probe.Predefined["ssh"].Expect {
+		t.Errorf("expected ssh preset banner pattern, got %v", cfg.Expect)
+	}
+}
+
+func TestParse_ExpectImpliesBanner(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-ssh", "-expect", "^SSH-1", "127.0.0.1"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Banner {
+		t.Error("expected -expect to enable banner check")
+	}
+	if cfg.Expect == nil || cfg.Expect.String() != "^SSH-1" {
+		t.Errorf("expected -expect to override preset pattern, got %v", cfg.Expect)
+	}
+}
+
+func TestParse_MultipleTargets(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-c", "1", "127.0.0.1:22", "dns://127.0.0.1", "https://127.0.0.1:8443"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Targets) != 3 {
+		t.Fatalf("expected 3 targets, got %d", len(cfg.Targets))
+	}
+	ssh, dns, https := cfg.Targets[0], cfg.Targets[1], cfg.Targets[2]
+	if ssh.Proto != models.TCP || ssh.Port != "22" || len(ssh.IPs) != 1 {
+		t.Errorf("unexpected tcp target %+v", ssh)
+	}
+	if dns.Proto != models.UDP || dns.Port != "53" || len(dns.UDPPayload) == 0 {
+		t.Errorf("unexpected dns target %+v", dns)
+	}
+	if https.Proto != models.TCP || !https.TLS || https.Port != "8443" {
+		t.Errorf("unexpected https target %+v", https)
+	}
+	if https.Count != 1 || https.TimeoutDur != time.Second {
+		t.Errorf("target should inherit session options, got %+v", https)
+	}
+}
+
+func TestParse_TargetsFile(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	path := t.TempDir() + "/targets.txt"
+	content := "# services\n127.0.0.1 5432\n\nssh://127.0.0.1\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Args = []string{"portping", "-f", path, "127.0.0.1:80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, tg := range cfg.Targets {
+		names = append(names, tg.Name())
+	}
+	want := "127.0.0.1:5432/tcp 127.0.0.1:22/tcp 127.0.0.1:80/tcp"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("targets = %q, expected %q", got, want)
+	}
+	if cfg.Targets[1].Preset != "ssh" || cfg.Targets[0].Preset != "" {
+		t.Error("expected the scheme to select the preset of a single target")
+	}
+}
+
+func TestParse_SingleTargetForms(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	// host:port followed by a payload is still a single UDP destination
+	os.Args = []string{"portping", "-udp", "127.0.0.1:53", "abcd"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Targets) != 0 || cfg.UDPPayloadHex != "abcd" {
+		t.Errorf("expected single UDP destination, got %+v", cfg)
+	}
+}
+
+func TestParse_InvalidTargets(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	tests := [][]string{
+		{"portping", "gopher://127.0.0.1:70", "127.0.0.1:80"},
+		{"portping", "-f", "/nonexistent/targets.txt"},
+		{"portping", "-f", os.DevNull},
+		{"portping", "udp://127.0.0.1:9", "127.0.0.1:80"},
+	}
+	for _, args := range tests {
+		os.Args = args
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if _, err := Parse(); err == nil {
+			t.Errorf("Parse(%q) expected error", args[1:])
+		}
+	}
+}
+
+func TestParse_PortSweep(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-workers", "8", "127.0.0.1", "8000-8002,22"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.IsSweep() || len(cfg.Ports) != 4 || cfg.Ports[0] != 22 || cfg.Workers != 8 {
+		t.Errorf("unexpected sweep config ports=%v workers=%d", cfg.Ports, cfg.Workers)
+	}
+
+	os.Args = []string{"portping", "127.0.0.1:80-80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if cfg, err = Parse(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IsSweep() || cfg.Port != "80" {
+		t.Errorf("single port range should be a plain ping, got %q %v", cfg.Port, cfg.Ports)
+	}
+
+	os.Args = []string{"portping", "127.0.0.1", "90-80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if _, err = Parse(); err == nil {
+		t.Error("expected error for reversed port range")
+	}
+}
+
+// dualStackDNS serves a single A and a single AAAA record for dual.test
+// and returns its UDP address.
+func dualStackDNS(t *testing.T) string {
+	addr, _ := dnstest.Start(t, dnstest.Zone{
+		Addrs: map[string][]string{"dual.test": {"192.0.2.1", "2001:db8::1"}},
+	})
+	return addr
+}
+
+func TestParse_DualStack(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-46", "-resolver", dualStackDNS(t), "dual.test", "443"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Dual || !cfg.AllowIPv4 || !cfg.AllowIPv6 {
+		t.Errorf("expected dual-stack config, got %+v", cfg)
+	}
+	if len(cfg.IPs) != 2 || cfg.IPs[0].IP != "192.0.2.1" || cfg.IPs[1].IP != "2001:db8::1" {
+		t.Errorf("expected 192.0.2.1 and 2001:db8::1, got %v", cfg.IPs)
+	}
+
+	os.Args = []string{"portping", "-dual", "127.0.0.1", "443"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if _, err := Parse(); err == nil || !strings.Contains(err.Error(), "no IPv6 address") {
+		t.Errorf("expected missing IPv6 error, got %v", err)
+	}
+}
+
+func TestParseResolver(t *testing.T) {
+	tests := []struct {
+		in   string
+		addr string
+		tcp  bool
+		err  bool
+	}{
+		{in: "10.0.0.53", addr: "10.0.0.53:53"},
+		{in: "10.0.0.53:5353", addr: "10.0.0.53:5353"},
+		{in: "udp://10.0.0.53", addr: "10.0.0.53:53"},
+		{in: "tcp://10.0.0.53", addr: "10.0.0.53:53", tcp: true},
+		{in: "TCP://[2001:db8::53]:53", addr: "[2001:db8::53]:53", tcp: true},
+		{in: "2001:db8::53", addr: "[2001:db8::53]:53"},
+		{in: "ns.example.net", addr: "ns.example.net:53"},
+		{in: "tls://10.0.0.53", err: true},
+		{in: "10.0.0.53:0", err: true},
+		{in: "tcp://", err: true},
+	}
+	for _, tt := range tests {
+		addr, tcp, err := parseResolver(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseResolver(%q) expected error, got %q", tt.in, addr)
+			}
+			continue
+		}
+		if err != nil || addr != tt.addr || tcp != tt.tcp {
+			t.Errorf("parseResolver(%q) = %q, %v, %v; expected %q, %v", tt.in, addr, tcp, err, tt.addr, tt.tcp)
+		}
+	}
+}
+
+func TestParse_Resolver(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-resolver", "tcp://127.0.0.1", "127.0.0.1", "80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Resolver != "127.0.0.1:53" || !cfg.ResolverTCP {
+		t.Errorf("expected TCP resolver 127.0.0.1:53, got %q (tcp %v)", cfg.Resolver, cfg.ResolverTCP)
+	}
+	if cfg.Resolution != nil {
+		t.Errorf("expected no resolution of an IP literal, got %+v", cfg.Resolution)
+	}
+}
+
+func TestSRVNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		proto models.Proto
+	}{
+		{"_postgres._tcp.db.internal", models.TCP},
+		{"_sip._UDP.example.com", models.UDP},
+		{"_ldap._sctp.example.com", ""},
+		{"_x._tcp", ""},
+		{"_._tcp.example.com", ""},
+		{"db.internal", ""},
+		{"postgres._tcp.db.internal", ""},
+	}
+	for _, tt := range tests {
+		if got := srvProto(tt.name); got != tt.proto {
+			t.Errorf("srvProto(%q) = %q, expected %q", tt.name, got, tt.proto)
+		}
+		if got := isTargetSpec(tt.name); got != (tt.proto != "") {
+			t.Errorf("isTargetSpec(%q) = %v", tt.name, got)
+		}
+	}
+
+	base := &models.Config{}
+	cfg, err := parseTarget(base, "_syslog._udp.example.com", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !isSRV(cfg) || cfg.Proto != models.UDP {
+		t.Errorf("expected a UDP SRV target, got %+v", cfg)
+	}
+	cfg, err = parseTarget(base, "_syslog._udp.example.com:514", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if isSRV(cfg) || cfg.Proto != models.TCP {
+		t.Errorf("a name with a port is a plain TCP host, got %+v", cfg)
+	}
+}
+
+func TestParse_Wait(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	tests := []struct {
+		args     []string
+		wait     models.WaitFor
+		deadline time.Duration
+		err      string
+	}{
+		{args: []string{"-wait", "-deadline", "30s"}, wait: models.WaitUp, deadline: 30 * time.Second},
+		{args: []string{"-wait-down"}, wait: models.WaitDown},
+		{args: []string{"-wait", "-wait-down"}, err: "both -wait and -wait-down"},
+		{args: []string{"-deadline", "-1s"}, err: "deadline must be"},
+	}
+	for _, tt := range tests {
+		os.Args = append(append([]string{"portping"}, tt.args...), "127.0.0.1", "5432")
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+		cfg, err := Parse()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: expected error %q, got %v", tt.args, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.args, err)
+		}
+		if cfg.Wait != tt.wait || cfg.Deadline != tt.deadline {
+			t.Errorf("%v: got wait %q deadline %v", tt.args, cfg.Wait, cfg.Deadline)
+		}
+	}
+
+	os.Args = []string{"portping", "-wait", "127.0.0.1", "8000-8010"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	if _, err := Parse(); err == nil || !strings.Contains(err.Error(), "port sweeps") {
+		t.Errorf("expected sweep error, got %v", err)
+	}
+}
+
+func TestParse_StopConditions(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-w", "10s", "-successes", "3", "-fail-streak", "5", "127.0.0.1", "80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Deadline != 10*time.Second || cfg.Successes != 3 || cfg.FailStreak != 5 || !cfg.Nonstop {
+		t.Errorf("unexpected stop conditions: deadline %v successes %d fail-streak %d nonstop %v",
+			cfg.Deadline, cfg.Successes, cfg.FailStreak, cfg.Nonstop)
+	}
+
+	for _, args := range [][]string{{"-successes", "-1"}, {"-fail-streak", "-2"}} {
+		os.Args = append(append([]string{"portping"}, args...), "127.0.0.1", "80")
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if _, err := Parse(); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		parse func(string) (float64, error)
+		want  models.Limit
+		err   bool
+	}{
+		{name: "max-loss", in: "", parse: parsePercent},
+		{name: "max-loss", in: "5%", parse: parsePercent, want: models.Limit{Crit: 5, HasCrit: true}},
+		{name: "max-loss", in: "1%,2.5", parse: parsePercent, want: models.Limit{Warn: 1, Crit: 2.5, HasWarn: true, HasCrit: true}},
+		{name: "max-loss", in: "0", parse: parsePercent, want: models.Limit{HasCrit: true}},
+		{name: "max-loss", in: "101%", parse: parsePercent, err: true},
+		{name: "max-loss", in: "10%,5%", parse: parsePercent, err: true},
+		{name: "max-avg", in: "50ms,0.1s", parse: parseMs, want: models.Limit{Warn: 50, Crit: 100, HasWarn: true, HasCrit: true}},
+		{name: "max-p95", in: "250", parse: parseMs, want: models.Limit{Crit: 250, HasCrit: true}},
+		{name: "max-p95", in: "fast", parse: parseMs, err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLimit(tt.name, tt.in, tt.parse)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "-"+tt.name) {
+				t.Errorf("parseLimit(%q) expected error naming -%s, got %v", tt.in, tt.name, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseLimit(%q) = %+v, %v; expected %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParse_Nagios(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-nagios", "-o", "text,json:run.json", "-max-loss", "5", "127.0.0.1", "80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []models.OutputSpec{{Format: models.OutputNagios}, {Format: models.OutputJSON, Path: "run.json"}}
+	if !reflect.DeepEqual(cfg.Outputs, want) {
+		t.Errorf("Outputs = %+v, expected %+v", cfg.Outputs, want)
+	}
+
+	// without a limit a dead service would be reported OK
+	for _, args := range [][]string{
+		{"-nagios", "127.0.0.1", "80"},
+		{"-o", "nagios", "127.0.0.1", "80"},
+	} {
+		os.Args = append([]string{"portping"}, args...)
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if _, err := Parse(); err == nil {
+			t.Errorf("Parse(%q) expected an error", args)
+		}
+	}
+}
+
+func TestNagiosRequested(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-nagios", "127.0.0.1", "80"}, true},
+		{[]string{"--nagios", "127.0.0.1", "80"}, true},
+		{[]string{"-nagios=true", "127.0.0.1", "80"}, true},
+		{[]string{"-nagios=false", "127.0.0.1", "80"}, false},
+		{[]string{"-c", "5", "-o", "nagios", "127.0.0.1", "80"}, true},
+		{[]string{"-o", "json:run.json,NAGIOS:status.txt", "127.0.0.1", "80"}, true},
+		{[]string{"-o=nagios", "-max-loss", "5x", "127.0.0.1", "80"}, true},
+		{[]string{"-o", "json", "127.0.0.1", "80"}, false},
+		{[]string{"--", "-nagios"}, false},
+	}
+	for _, tt := range tests {
+		if got := NagiosRequested(tt.args); got != tt.want {
+			t.Errorf("NagiosRequested(%q) = %v, expected %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestParseServe(t *testing.T) {
+	cfg, listen, err := ParseServe([]string{"-listen", "127.0.0.1:9200", "-t", "500"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listen != "127.0.0.1:9200" {
+		t.Errorf("listen = %q, expected 127.0.0.1:9200", listen)
+	}
+	if cfg.Host != "" || len(cfg.Targets) != 0 {
+		t.Errorf("expected no targets, got host %q and %d targets", cfg.Host, len(cfg.Targets))
+	}
+	if cfg.Timeout != 500 {
+		t.Errorf("Timeout = %d, expected 500", cfg.Timeout)
+	}
+
+	cfg, listen, err = ParseServe([]string{"127.0.0.1:80", "tcp://127.0.0.1:443"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listen != ":9115" {
+		t.Errorf("listen = %q, expected the default :9115", listen)
+	}
+	if len(cfg.Targets) != 2 {
+		t.Errorf("expected 2 targets, got %d", len(cfg.Targets))
+	}
+
+	if _, _, err := ParseServe([]string{"-t", "0"}); err == nil {
+		t.Error("expected an error for an invalid timeout")
+	}
+}
+
+func TestProbeTarget(t *testing.T) {
+	base, _, err := ParseServe(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg, err := ProbeTarget(base, "127.0.0.1:53", "dns")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Preset != "dns" || !cfg.IsUDP() || len(cfg.IPs) != 1 {
+		t.Errorf("got preset %q, proto %s and %d IPs, expected a dns probe of 1 IP", cfg.Preset, cfg.Proto, len(cfg.IPs))
+	}
+
+	cfg, err = ProbeTarget(base, "tcp://127.0.0.1:9000", "dns")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Preset != "" || !cfg.IsTCP() || cfg.Port != "9000" {
+		t.Errorf("got preset %q, proto %s and port %s, expected the target scheme to win", cfg.Preset, cfg.Proto, cfg.Port)
+	}
+
+	for _, tt := range []struct{ target, preset string }{
+		{"", ""},
+		{"127.0.0.1:53", "nope"},
+		{"127.0.0.1", ""},
+		{"127.0.0.1:1-100", ""},
+	} {
+		if _, err := ProbeTarget(base, tt.target, tt.preset); err == nil {
+			t.Errorf("ProbeTarget(%q, %q) expected an error", tt.target, tt.preset)
+		}
+	}
+}
+
+func TestParseListen(t *testing.T) {
+	cfg, err := ParseListen([]string{"-udp", "9000", "-tcp", "127.0.0.1:9001", "-timestamp"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &models.Listen{UDP: ":9000", TCP: "127.0.0.1:9001", Timestamp: true}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("ParseListen() = %+v, expected %+v", cfg, want)
+	}
+
+	for _, args := range [][]string{
+		nil,
+		{"-timestamp"},
+		{"-udp", "host"},
+		{"-tcp", ":70000"},
+		{"-udp", ":9000", "extra"},
+	} {
+		if _, err := ParseListen(args); err == nil {
+			t.Errorf("ParseListen(%q) expected an error", args)
+		}
+	}
+}
+
+func TestParse_TWAMP(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-twamp", "127.0.0.1", "9000"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.TWAMP || cfg.Preset != "echo" || !cfg.IsUDP() || cfg.Port != "9000" {
+		t.Errorf("got twamp %v, preset %q, proto %s, port %s", cfg.TWAMP, cfg.Preset, cfg.Proto, cfg.Port)
+	}
+
+	for _, args := range [][]string{
+		{"-twamp", "-dns", "127.0.0.1"},
+		{"-twamp", "127.0.0.1", "9000-9002"},
+	} {
+		os.Args = append([]string{"portping"}, args...)
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if _, err := Parse(); err == nil {
+			t.Errorf("Parse(%q) expected an error", args)
+		}
+	}
+}
+
+func TestParse_Source(t *testing.T) {
+	original := flag.CommandLine
+	defer func() { flag.CommandLine = original }()
+
+	os.Args = []string{"portping", "-source", "127.0.0.1", "-sport", "40000", "localhost", "80"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg, err := Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Source != "127.0.0.1" || cfg.SourcePort != 40000 || !cfg.AllowIPv4 || cfg.AllowIPv6 {
+		t.Errorf("got source %q, sport %d, ipv4 %v, ipv6 %v", cfg.Source, cfg.SourcePort, cfg.AllowIPv4, cfg.AllowIPv6)
+	}
+	if got := cfg.SourceName(); got != "127.0.0.1:40000" {
+		t.Errorf("SourceName() = %q", got)
+	}
+
+	for _, args := range [][]string{
+		{"-source", "nowhere", "127.0.0.1", "80"},
+		{"-source", "127.0.0.1", "::1", "80"},
+		{"-source", "::1", "127.0.0.1", "80"},
+		{"-source", "127.0.0.1", "-6", "localhost", "80"},
+		{"-source", "127.0.0.1", "-46", "localhost", "80"},
+		{"-sport", "70000", "127.0.0.1", "80"},
+		{"-interface", "no-such-if0", "127.0.0.1", "80"},
+	} {
+		os.Args = append([]string{"portping"}, args...)
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		if _, err := Parse(); err == nil {
+			t.Errorf("Parse(%q) expected an error", args)
+		}
+	}
+}
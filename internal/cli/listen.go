@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sopov/portping/internal/app"
+	"github.com/sopov/portping/internal/helpers"
+	"github.com/sopov/portping/internal/models"
+	"net"
+)
+
+// ParseListen parses the arguments of "portping listen", the echo
+// reflector. Addresses are "[host]:port" or a bare port.
+func ParseListen(args []string) (*models.Listen, error) {
+	fs := flag.NewFlagSet(app.Name+" listen", flag.ContinueOnError)
+	cfg := &models.Listen{}
+	fs.StringVar(&cfg.UDP, "udp", "", "Echo UDP datagrams received on `address` (e.g. :9000)")
+	fs.StringVar(&cfg.TCP, "tcp", "", "Echo TCP connections accepted on `address`")
+	fs.BoolVar(&cfg.Timestamp, "timestamp", false, "Stamp portping echo packets with the receive and transmit times")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument `%s`", fs.Arg(0))
+	}
+	if cfg.UDP == "" && cfg.TCP == "" {
+		return nil, fmt.Errorf("-udp or -tcp is required")
+	}
+	for _, addr := range []*string{&cfg.UDP, &cfg.TCP} {
+		if *addr == "" {
+			continue
+		}
+		if helpers.ValidPort(*addr) {
+			*addr = ":" + *addr
+		}
+		_, port, err := net.SplitHostPort(*addr)
+		if err != nil || !helpers.ValidPort(port) {
+			return nil, fmt.Errorf("invalid listen address `%s`", *addr)
+		}
+	}
+	return cfg, nil
+}
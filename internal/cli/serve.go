@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sopov/portping/internal/app"
+	"github.com/sopov/portping/internal/models"
+	"github.com/sopov/portping/internal/probe"
+	"strings"
+)
+
+// ParseServe parses the arguments of "portping serve": the listen address,
+// the probe options and the targets to ping continuously for /metrics.
+// Targets are optional, a server without them only answers /probe.
+func ParseServe(args []string) (cfg *models.Config, listen string, err error) {
+	fs := flag.NewFlagSet(app.Name+" serve", flag.ContinueOnError)
+	fs.StringVar(&listen, "listen", ":9115", "HTTP listen `address` of /metrics and /probe")
+	cfg, err = parseOptions(fs, args)
+	if err != nil {
+		return nil, "", err
+	}
+	if fs.NArg() == 0 {
+		if err := parsePresetFlags(fs, cfg); err != nil {
+			return nil, "", err
+		}
+		return cfg, listen, validateOptions(cfg)
+	}
+	return cfg, listen, parseDestinations(fs, cfg)
+}
+
+// ProbeTarget builds the config of a one-shot probe of target, written as
+// "[scheme://]host:port", from the serve options in base. A non-empty
+// preset selects a preset or protocol the way a scheme does.
+func ProbeTarget(base *models.Config, target, preset string) (*models.Config, error) {
+	if target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+	if preset != "" {
+		preset = strings.ToLower(preset)
+		if _, ok := probe.GetPreset(preset); !ok {
+			switch models.Proto(preset) {
+			case models.TCP, models.UDP, models.HTTP:
+			default:
+				return nil, fmt.Errorf("unknown preset `%s`", preset)
+			}
+		}
+		if !strings.Contains(target, "://") {
+			target = preset + "://" + target
+		}
+	}
+	t, err := parseTarget(base, target, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDestination(t); err != nil {
+		return nil, err
+	}
+	if t.IsSweep() {
+		return nil, fmt.Errorf("port sweeps are not supported by /probe")
+	}
+	if err := resolve(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
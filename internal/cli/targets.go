@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sopov/portping/internal/models"
+	"github.com/sopov/portping/internal/probe"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// targetSpec is a single destination of a multi-target session as written
+// by the user: "[scheme://]host[:port]", optionally followed by the port.
+type targetSpec struct {
+	addr string
+	port string
+}
+
+// targetSpecs returns the destinations of a multi-target session, or nil
+// when the arguments describe a single destination in the classic
+// "<destination> <port> [payload]" form. Targets are taken from the -f file
+// followed by the arguments, which must then all be target specs.
+func targetSpecs(args []string) ([]targetSpec, error) {
+	multi := cfgFlags.targetsFile != ""
+	if !multi && len(args) > 0 {
+		multi = true
+		for _, a := range args {
+			if !isTargetSpec(a) {
+				multi = false
+				break
+			}
+		}
+		if len(args) == 1 && !strings.Contains(args[0], "://") {
+			multi = false
+		}
+	}
+	if !multi {
+		return nil, nil
+	}
+
+	var specs []targetSpec
+	if cfgFlags.targetsFile != "" {
+		fromFile, err := readTargetsFile(cfgFlags.targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, fromFile...)
+	}
+	for _, a := range args {
+		if !isTargetSpec(a) {
+			return nil, fmt.Errorf("invalid target `%s`, expected [scheme://]host:port", a)
+		}
+		specs = append(specs, targetSpec{addr: a})
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no targets found in `%s`", cfgFlags.targetsFile)
+	}
+	return specs, nil
+}
+
+// isTargetSpec reports whether a command line argument names a complete
+// target, i.e. carries a scheme or a port or is an SRV name.
+func isTargetSpec(s string) bool {
+	if strings.Contains(s, "://") || srvProto(s) != "" {
+		return true
+	}
+	_, port, err := trySplitHostPort(s)
+	return err == nil && port != ""
+}
+
+// readTargetsFile reads one target per line; empty lines and lines
+// starting with # are skipped.
+func readTargetsFile(path string) ([]targetSpec, error) {
+	f, err := os.Open(path) // #nosec G304 -- path is supplied by the user
+	if err != nil {
+		return nil, fmt.Errorf("read targets file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var specs []targetSpec
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			specs = append(specs, targetSpec{addr: fields[0]})
+		case 2:
+			specs = append(specs, targetSpec{addr: fields[0], port: fields[1]})
+		default:
+			return nil, fmt.Errorf("%s:%d: invalid target `%s`", path, n, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read targets file: %w", err)
+	}
+	return specs, nil
+}
+
+// parseTarget builds the config of a single target from the session
+// defaults in base. The scheme selects a preset or a protocol for this
+// target only; without it the target inherits -preset and -proto.
+func parseTarget(base *models.Config, addr, port string) (*models.Config, error) {
+	t := *base
+	t.Targets = nil
+	t.IPs = nil
+	t.Ports = nil
+
+	if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+		scheme = strings.ToLower(scheme)
+		addr = rest
+		if pr, isPreset := probe.GetPreset(scheme); isPreset {
+			t.Preset = scheme
+			// -proto http upgrades the TCP presets to an HTTP request
+			if base.Proto != models.HTTP || pr.Proto != models.TCP {
+				t.Proto = ""
+			}
+		} else {
+			switch proto := models.Proto(scheme); proto {
+			case models.TCP, models.UDP, models.HTTP:
+				t.Proto = proto
+				t.Preset = ""
+			default:
+				return nil, fmt.Errorf("invalid target scheme `%s`", scheme)
+			}
+		}
+	}
+
+	t.Host = strings.Trim(addr, "[]")
+	t.Port = port
+	if port == "" {
+		if h, p, err := trySplitHostPort(addr); err == nil {
+			t.Host, t.Port = h, p
+		}
+	}
+	if t.Host == "" {
+		return nil, fmt.Errorf("invalid target `%s`", addr)
+	}
+	if t.Port == "" && t.Proto == "" {
+		t.Proto = srvProto(t.Host)
+	}
+
+	if t.Preset != "" {
+		if err := applyPreset(&t); err != nil {
+			return nil, err
+		}
+	}
+	if err := completeTarget(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// srvProto returns the protocol of an SRV name like "_ldap._tcp.example.com",
+// or "" when name is not an SRV name.
+func srvProto(name string) models.Proto {
+	labels := strings.SplitN(name, ".", 3)
+	if len(labels) < 3 || len(labels[0]) < 2 || labels[0][0] != '_' || labels[2] == "" {
+		return ""
+	}
+	switch strings.ToLower(labels[1]) {
+	case "_tcp":
+		return models.TCP
+	case "_udp":
+		return models.UDP
+	}
+	return ""
+}
+
+// isSRV reports whether the destination of cfg is an SRV name to expand.
+func isSRV(cfg *models.Config) bool {
+	return cfg.Port == "" && srvProto(cfg.Host) != ""
+}
+
+// expandSRV replaces every destination given as an SRV name by one target
+// per SRV record, keeping the order of the targets.
+func expandSRV(cfg *models.Config) error {
+	if len(cfg.Targets) == 0 {
+		if !isSRV(cfg) {
+			return nil
+		}
+		targets, err := srvTargets(cfg)
+		cfg.Targets = targets
+		return err
+	}
+	expanded := make([]*models.Config, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		if !isSRV(t) {
+			expanded = append(expanded, t)
+			continue
+		}
+		targets, err := srvTargets(t)
+		if err != nil {
+			return fmt.Errorf("target `%s`: %w", t.Host, err)
+		}
+		expanded = append(expanded, targets...)
+	}
+	cfg.Targets = expanded
+	return nil
+}
+
+// srvTargets looks up the SRV name of cfg and returns a copy of cfg for
+// every record.
+func srvTargets(cfg *models.Config) ([]*models.Config, error) {
+	records, err := probe.LookupSRV(cfg)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]*models.Config, 0, len(records))
+	for _, rec := range records {
+		t := *cfg
+		t.Targets = nil
+		t.IPs = nil
+		t.Host = rec.Host
+		t.Port = strconv.Itoa(int(rec.Port))
+		t.SRV = &models.SRV{Name: cfg.Host, Priority: rec.Priority, Weight: rec.Weight}
+		targets = append(targets, &t)
+	}
+	return targets, nil
+}
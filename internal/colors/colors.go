@@ -1,6 +1,9 @@
 package colors
 
-import "github.com/fatih/color"
+import (
+	"fmt"
+	"github.com/fatih/color"
+)
 
 var (
 	HRed = color.New(color.FgHiRed).SprintFunc()
@@ -16,3 +19,35 @@ var (
 func NoColor(disable bool) {
 	color.NoColor = disable
 }
+
+// Palette holds the color functions of a single output, so that text on a
+// terminal can be colored while the same text written to a file is not.
+type Palette struct {
+	HRed, Red       func(a ...any) string
+	Yellow, HYellow func(a ...any) string
+	Green, HGreen   func(a ...any) string
+	enabled         bool
+}
+
+// NewPalette returns a palette that colors text when enabled, unless colors
+// are off altogether, e.g. because stdout is not a terminal.
+func NewPalette(enabled bool) Palette {
+	if !enabled || color.NoColor {
+		return Palette{
+			HRed: fmt.Sprint, Red: fmt.Sprint,
+			Yellow: fmt.Sprint, HYellow: fmt.Sprint,
+			Green: fmt.Sprint, HGreen: fmt.Sprint,
+		}
+	}
+	return Palette{
+		HRed: HRed, Red: Red,
+		Yellow: Yellow, HYellow: HYellow,
+		Green: Green, HGreen: HGreen,
+		enabled: true,
+	}
+}
+
+// Enabled reports whether the palette adds color codes.
+func (p Palette) Enabled() bool {
+	return p.enabled
+}
@@ -52,3 +52,24 @@ func TestColorFunctions(t *testing.T) {
 	}
 }
 
+
+func TestNewPalette(t *testing.T) {
+	originalValue := color.NoColor
+	defer NoColor(originalValue)
+	NoColor(false)
+
+	on := NewPalette(true)
+	if !on.Enabled() || on.HRed("test") == "test" {
+		t.Errorf("NewPalette(true) should color text, got %q", on.HRed("test"))
+	}
+	off := NewPalette(false)
+	if off.Enabled() || off.HRed("test") != "test" || off.Green("test") != "test" {
+		t.Errorf("NewPalette(false) should leave text as is, got %q", off.HRed("test"))
+	}
+
+	// colors that are off altogether stay off
+	NoColor(true)
+	if NewPalette(true).Enabled() {
+		t.Error("NewPalette(true) should not color text when colors are off")
+	}
+}
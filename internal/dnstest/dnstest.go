@@ -0,0 +1,161 @@
+package dnstest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+const (
+	typeA     = 1
+	typeCNAME = 5
+	typeAAAA  = 28
+	typeSRV   = 33
+)
+
+// SRV is a service record served by a Zone.
+type SRV struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// Zone is the data a stub server answers from. Names are lower case and
+// without the trailing dot.
+type Zone struct {
+	// CNAME maps an alias to its target; chains are followed.
+	CNAME map[string]string
+	// Addrs are the IPv4 and IPv6 addresses of a name, answered to A and
+	// AAAA questions respectively.
+	Addrs map[string][]string
+	// SRV are the service records of a name in answer order.
+	SRV map[string][]SRV
+}
+
+// Start serves the zone over UDP and TCP on loopback until the test ends
+// and returns both addresses.
+func Start(t testing.TB, zone Zone) (udpAddr, tcpAddr string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := zone.answer(buf[:n]); resp != nil {
+				_, _ = pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var l [2]byte
+					if _, err := io.ReadFull(conn, l[:]); err != nil {
+						return
+					}
+					req := make([]byte, binary.BigEndian.Uint16(l[:]))
+					if _, err := io.ReadFull(conn, req); err != nil {
+						return
+					}
+					resp := zone.answer(req)
+					if resp == nil {
+						return
+					}
+					_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+				}
+			}()
+		}
+	}()
+	return pc.LocalAddr().String(), ln.Addr().String()
+}
+
+// answer builds the response of the zone to a single uncompressed question.
+func (z Zone) answer(req []byte) []byte {
+	if len(req) < 12 {
+		return nil
+	}
+	var labels []string
+	end := 12
+	for end < len(req) && req[end] != 0 {
+		l := int(req[end])
+		if l > 63 || end+1+l > len(req) {
+			return nil
+		}
+		labels = append(labels, string(req[end+1:end+1+l]))
+		end += 1 + l
+	}
+	end++ // root label
+	if end+4 > len(req) {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+	qtype := binary.BigEndian.Uint16(req[end:])
+
+	resp := append([]byte{}, req[:end+4]...)
+	binary.BigEndian.PutUint16(resp[2:], 0x8180) // response, RD, RA
+	binary.BigEndian.PutUint16(resp[10:], 0)     // no additional records
+	answers := 0
+	record := func(typ uint16, rdata []byte) {
+		resp = putName(resp, name)
+		resp = binary.BigEndian.AppendUint16(resp, typ)
+		resp = binary.BigEndian.AppendUint16(resp, 1)  // IN
+		resp = binary.BigEndian.AppendUint32(resp, 60) // TTL
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+		answers++
+	}
+	for target, ok := z.CNAME[name]; ok; target, ok = z.CNAME[name] {
+		record(typeCNAME, putName(nil, target))
+		name = target
+	}
+	switch qtype {
+	case typeA, typeAAAA:
+		for _, addr := range z.Addrs[name] {
+			ip := net.ParseIP(addr)
+			if ip4 := ip.To4(); qtype == typeA && ip4 != nil {
+				record(typeA, ip4)
+			} else if qtype == typeAAAA && ip4 == nil && ip != nil {
+				record(typeAAAA, ip)
+			}
+		}
+	case typeSRV:
+		for _, srv := range z.SRV[name] {
+			rdata := binary.BigEndian.AppendUint16(nil, srv.Priority)
+			rdata = binary.BigEndian.AppendUint16(rdata, srv.Weight)
+			rdata = binary.BigEndian.AppendUint16(rdata, srv.Port)
+			record(typeSRV, putName(rdata, srv.Target))
+		}
+	}
+	binary.BigEndian.PutUint16(resp[6:], uint16(answers))
+	return resp
+}
+
+func putName(b []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
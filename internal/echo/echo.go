@@ -0,0 +1,217 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Magic starts every portping echo packet.
+const Magic = "PPNG"
+
+// PacketSize is the size of a portping echo packet; longer datagrams
+// carry padding after the packet.
+const PacketSize = 44
+
+const (
+	version     = 1
+	flagStamped = 0x01
+)
+
+// Packet is a timestamped echo request and, once stamped by a reflector,
+// its reply. Layout, big-endian:
+//
+//	0  magic "PPNG"      12  T1 sent, unix ns
+//	4  version           20  T2 received by the reflector, unix ns
+//	5  flags             28  T3 transmitted by the reflector, unix ns
+//	8  sequence          36  session
+//	                     40  reflector sequence
+type Packet struct {
+	Seq uint32
+	// Sent is set by the client, Received and Transmitted by the reflector.
+	Sent        time.Time
+	Received    time.Time
+	Transmitted time.Time
+	Stamped     bool
+	// Session identifies the packets of a two-way session; the reflector
+	// numbers the packets it receives per session in ReflectorSeq, which
+	// separates forward from reverse loss. Session 0 is not numbered.
+	Session      uint32
+	ReflectorSeq uint32
+}
+
+// Marshal encodes p.
+func (p *Packet) Marshal() []byte {
+	b := make([]byte, PacketSize)
+	copy(b, Magic)
+	b[4] = version
+	if p.Stamped {
+		b[5] = flagStamped
+	}
+	binary.BigEndian.PutUint32(b[8:], p.Seq)
+	putTime(b[12:], p.Sent)
+	putTime(b[20:], p.Received)
+	putTime(b[28:], p.Transmitted)
+	binary.BigEndian.PutUint32(b[36:], p.Session)
+	binary.BigEndian.PutUint32(b[40:], p.ReflectorSeq)
+	return b
+}
+
+// Parse decodes the echo packet at the start of b.
+func Parse(b []byte) (*Packet, bool) {
+	if !IsPacket(b) {
+		return nil, false
+	}
+	return &Packet{
+		Seq:          binary.BigEndian.Uint32(b[8:]),
+		Sent:         getTime(b[12:]),
+		Received:     getTime(b[20:]),
+		Transmitted:  getTime(b[28:]),
+		Stamped:      b[5]&flagStamped != 0,
+		Session:      binary.BigEndian.Uint32(b[36:]),
+		ReflectorSeq: binary.BigEndian.Uint32(b[40:]),
+	}, true
+}
+
+// IsPacket reports whether b starts with a portping echo packet.
+func IsPacket(b []byte) bool {
+	return len(b) >= PacketSize && bytes.HasPrefix(b, []byte(Magic)) && b[4] == version
+}
+
+// SetSent writes the client transmit time into the packet at the start of b.
+func SetSent(b []byte, t time.Time) {
+	if IsPacket(b) {
+		putTime(b[12:], t)
+	}
+}
+
+// Stamp writes the reflector times into the packet at the start of b and
+// marks it stamped; other data is left alone.
+func Stamp(b []byte, received, transmitted time.Time) {
+	if !IsPacket(b) {
+		return
+	}
+	b[5] |= flagStamped
+	putTime(b[20:], received)
+	putTime(b[28:], transmitted)
+}
+
+func putTime(b []byte, t time.Time) {
+	var ns int64
+	if !t.IsZero() {
+		ns = t.UnixNano()
+	}
+	binary.BigEndian.PutUint64(b, uint64(ns))
+}
+
+func getTime(b []byte) time.Time {
+	ns := int64(binary.BigEndian.Uint64(b))
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// maxDatagram is the largest datagram that is echoed.
+const maxDatagram = 65535
+
+// ServeUDP sends every datagram received on conn back to its sender until
+// ctx is canceled. With stamp set, portping echo packets are stamped with
+// the receive and transmit times and numbered per session; anything else
+// is echoed unchanged.
+func ServeUDP(ctx context.Context, conn net.PacketConn, stamp bool) error {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sessions := make(sessions)
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		received := time.Now()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if stamp && IsPacket(buf[:n]) {
+			if id := binary.BigEndian.Uint32(buf[36:]); id != 0 {
+				binary.BigEndian.PutUint32(buf[40:], sessions.next(addr, id, received))
+			}
+			Stamp(buf[:n], received, time.Now())
+		}
+		_, _ = conn.WriteTo(buf[:n], addr)
+	}
+}
+
+// maxSessions bounds the reflector session table; idle sessions are
+// dropped once it is full.
+const (
+	maxSessions = 4096
+	sessionIdle = time.Minute
+)
+
+type sessionKey struct {
+	host string
+	id   uint32
+}
+
+type sessionState struct {
+	seq  uint32
+	seen time.Time
+}
+
+// sessions numbers the packets of every two-way session.
+type sessions map[sessionKey]*sessionState
+
+// next returns the reflector sequence number of a packet of session id
+// from addr.
+func (s sessions) next(addr net.Addr, id uint32, now time.Time) uint32 {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	key := sessionKey{host: host, id: id}
+	st := s[key]
+	if st == nil {
+		if len(s) >= maxSessions {
+			for k, v := range s {
+				if now.Sub(v.seen) > sessionIdle {
+					delete(s, k)
+				}
+			}
+		}
+		st = &sessionState{}
+		s[key] = st
+	}
+	st.seq++
+	st.seen = now
+	return st.seq
+}
+
+// ServeTCP echoes the data of every connection accepted on ln until ctx
+// is canceled.
+func ServeTCP(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = ln.Close() })
+	defer stop()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			defer func() { _ = conn.Close() }()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
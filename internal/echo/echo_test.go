@@ -0,0 +1,128 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacket(t *testing.T) {
+	sent := time.Unix(1700000000, 123456789)
+	p := &Packet{Seq: 7, Sent: sent, Session: 3, ReflectorSeq: 9}
+	b := p.Marshal()
+	if len(b) != PacketSize || !IsPacket(b) {
+		t.Fatalf("Marshal() = %x, expected a %d byte packet", b, PacketSize)
+	}
+	got, ok := Parse(b)
+	if !ok || got.Seq != 7 || !got.Sent.Equal(sent) || got.Stamped || !got.Received.IsZero() || got.Session != 3 || got.ReflectorSeq != 9 {
+		t.Fatalf("Parse() = %+v, %v", got, ok)
+	}
+
+	Stamp(b, sent.Add(time.Millisecond), sent.Add(2*time.Millisecond))
+	got, _ = Parse(b)
+	if !got.Stamped || got.Received.Sub(sent) != time.Millisecond || got.Transmitted.Sub(sent) != 2*time.Millisecond {
+		t.Errorf("Parse() after Stamp() = %+v", got)
+	}
+
+	for _, b := range [][]byte{nil, []byte("PPNG"), bytes.Repeat([]byte{0}, PacketSize)} {
+		if IsPacket(b) {
+			t.Errorf("IsPacket(%x) = true", b)
+		}
+	}
+}
+
+func TestServeUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ServeUDP(ctx, conn, true) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	exchange := func(req []byte) []byte {
+		t.Helper()
+		_ = client.SetDeadline(time.Now().Add(time.Second))
+		if _, err := client.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+
+	// RFC 862: anything else comes back unchanged
+	if got := exchange([]byte("hello")); string(got) != "hello" {
+		t.Errorf("echo of plain data = %q", got)
+	}
+
+	req := append((&Packet{Seq: 1, Sent: time.Now()}).Marshal(), "padding"...)
+	resp := exchange(req)
+	p, ok := Parse(resp)
+	if !ok || !p.Stamped || p.Seq != 1 || p.Transmitted.Before(p.Received) {
+		t.Errorf("echo of packet = %+v, %v", p, ok)
+	}
+	if !bytes.HasSuffix(resp, []byte("padding")) {
+		t.Errorf("echo lost the padding: %q", resp[PacketSize:])
+	}
+	if p.ReflectorSeq != 0 {
+		t.Errorf("packet without session numbered %d", p.ReflectorSeq)
+	}
+
+	// the packets of a session are numbered in the order they arrive
+	for i, seq := range []uint32{1, 2, 5} {
+		p, _ := Parse(exchange((&Packet{Seq: seq, Session: 42, Sent: time.Now()}).Marshal()))
+		if p.Session != 42 || p.ReflectorSeq != uint32(i+1) {
+			t.Errorf("packet %d: session %d, reflector seq %d, expected 42 and %d", seq, p.Session, p.ReflectorSeq, i+1)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ServeUDP() = %v, expected nil after cancel", err)
+	}
+}
+
+func TestServeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ServeTCP(ctx, ln) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("echo = %q, %v", buf, err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("ServeTCP() = %v, expected nil after cancel", err)
+	}
+	// open connections are closed on shutdown
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,261 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sopov/portping/internal/models"
+	"github.com/sopov/portping/internal/stats"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets are the upper bounds in seconds of the RTT histograms.
+var Buckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// TargetFunc builds the config of a one-shot probe from the target and
+// preset parameters of a /probe request.
+type TargetFunc func(target, preset string) (*models.Config, error)
+
+// Exporter serves Prometheus metrics over HTTP: /metrics for the targets
+// of a continuous session, which feeds the Exporter as its reporter, and
+// /probe for one-shot probes in the style of the blackbox exporter.
+type Exporter struct {
+	target TargetFunc
+
+	mu     sync.Mutex
+	series []*series
+}
+
+// series holds the statistics of one IP of a target.
+type series struct {
+	cfg   *models.Config
+	ip    string
+	stats models.Stats
+	// buckets counts the successful attempts per bucket, the last one is +Inf.
+	buckets []int
+	up      bool
+}
+
+func New(target TargetFunc) *Exporter {
+	return &Exporter{target: target}
+}
+
+// Handler returns the HTTP handler of /metrics and /probe.
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.serveMetrics)
+	mux.HandleFunc("/probe", e.serveProbe)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "portping exporter: /metrics, /probe?target=host:port[&preset=name]")
+	})
+	return mux
+}
+
+// Serve answers HTTP requests on ln until ctx is canceled.
+func (e *Exporter) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           e.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (e *Exporter) OnStart(cfg *models.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ip := range cfg.IPs {
+		e.lookup(cfg, ip.IP)
+	}
+}
+
+func (e *Exporter) OnResult(cfg *models.Config, res models.Result) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.lookup(cfg, res.IP.IP)
+	stats.Add(&s.stats, res)
+	s.up = res.Err == nil
+	if res.Err == nil {
+		s.buckets[bucket(res.Duration)]++
+	}
+}
+
+// OnEvent registers the addresses added by a new DNS answer.
+func (e *Exporter) OnEvent(cfg *models.Config, ev models.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ip := range ev.Added {
+		e.lookup(cfg, ip.IP)
+	}
+}
+
+func (e *Exporter) OnSummary(*models.Config, map[string]*models.Stats) {}
+
+// lookup returns the series of ip of cfg, creating it on first use; the
+// series are kept in the order they were seen. e.mu must be held.
+func (e *Exporter) lookup(cfg *models.Config, ip string) *series {
+	for _, s := range e.series {
+		if s.cfg == cfg && s.ip == ip {
+			return s
+		}
+	}
+	s := &series{cfg: cfg, ip: ip, buckets: make([]int, len(Buckets)+1)}
+	e.series = append(e.series, s)
+	return s
+}
+
+// bucket returns the index of the histogram bucket of d.
+func bucket(d time.Duration) int {
+	for i, le := range Buckets {
+		if d.Seconds() <= le {
+			return i
+		}
+	}
+	return len(Buckets)
+}
+
+// snapshot copies the series for /metrics, so that a slow scraper does not
+// hold e.mu and stall the probes. Only the values written there are kept.
+func (e *Exporter) snapshot() []*series {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]*series, len(e.series))
+	for i, s := range e.series {
+		out[i] = &series{
+			cfg:     s.cfg,
+			ip:      s.ip,
+			buckets: slices.Clone(s.buckets),
+			up:      s.up,
+			stats: models.Stats{
+				Attempts: s.stats.Attempts,
+				Connects: s.stats.Connects,
+				Failures: s.stats.Failures,
+				Total:    s.stats.Total,
+				Errors:   maps.Clone(s.stats.Errors),
+			},
+		}
+	}
+	return out
+}
+
+func (e *Exporter) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	all := e.snapshot()
+	m := &metricWriter{w: w}
+
+	counters := []struct {
+		name, help string
+		value      func(*models.Stats) int
+	}{
+		{"portping_attempts_total", "Attempts to connect to the address.", func(st *models.Stats) int { return st.Attempts }},
+		{"portping_connects_total", "Successful attempts.", func(st *models.Stats) int { return st.Connects }},
+		{"portping_failures_total", "Failed attempts.", func(st *models.Stats) int { return st.Failures }},
+	}
+	for _, c := range counters {
+		m.header(c.name, "counter", c.help)
+		for _, s := range all {
+			m.sample(c.name, s.labels(), float64(c.value(&s.stats)))
+		}
+	}
+
+	m.header("portping_errors_total", "counter", "Failed attempts per failure category.")
+	for _, s := range all {
+		for _, class := range models.ErrClasses {
+			if n := s.stats.Errors[class]; n > 0 {
+				m.sample("portping_errors_total", append(s.labels(), "class", class.String()), float64(n))
+			}
+		}
+	}
+
+	m.header("portping_up", "gauge", "Whether the last attempt succeeded.")
+	for _, s := range all {
+		m.sample("portping_up", s.labels(), boolValue(s.up))
+	}
+
+	m.header("portping_rtt_seconds", "histogram", "Round-trip time of successful attempts.")
+	for _, s := range all {
+		cumulative := 0
+		for i, n := range s.buckets {
+			cumulative += n
+			le := "+Inf"
+			if i < len(Buckets) {
+				le = formatFloat(Buckets[i])
+			}
+			m.sample("portping_rtt_seconds_bucket", append(s.labels(), "le", le), float64(cumulative))
+		}
+		m.sample("portping_rtt_seconds_sum", s.labels(), s.stats.Total.Seconds())
+		m.sample("portping_rtt_seconds_count", s.labels(), float64(s.stats.Connects))
+	}
+}
+
+// labels returns the label pairs identifying the series.
+func (s *series) labels() []string {
+	return []string{"target", s.cfg.Name(), "ip", s.ip}
+}
+
+// contentType is the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricWriter writes metrics in the Prometheus text exposition format.
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m *metricWriter) header(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a line of name with labels given as name/value pairs.
+func (m *metricWriter) sample(name string, labels []string, v float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(m.w, "%s %s\n", b.String(), formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
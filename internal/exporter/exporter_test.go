@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"errors"
+	"github.com/sopov/portping/internal/models"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, srv *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestExporter_Metrics(t *testing.T) {
+	e := New(nil)
+	cfg := &models.Config{
+		Host:  "db",
+		Port:  "5432",
+		Proto: models.TCP,
+		IPs:   []models.IP{{IP: "10.0.0.1", IsIPv4: true}, {IP: "10.0.0.2", IsIPv4: true}},
+	}
+	e.OnStart(cfg)
+	ip := cfg.IPs[0]
+	e.OnResult(cfg, models.Result{IP: ip, Duration: 3 * time.Millisecond})
+	e.OnResult(cfg, models.Result{IP: ip, Duration: 20 * time.Millisecond})
+	e.OnResult(cfg, models.Result{IP: ip, Duration: time.Second, Err: errors.New("timeout"), ErrClass: models.ErrTimeout})
+
+	srv := httptest.NewServer(e.Handler())
+	defer srv.Close()
+	code, body := get(t, srv, "/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	for _, want := range []string{
+		"# TYPE portping_attempts_total counter\n",
+		`portping_attempts_total{target="db:5432/tcp",ip="10.0.0.1"} 3` + "\n",
+		`portping_connects_total{target="db:5432/tcp",ip="10.0.0.1"} 2` + "\n",
+		`portping_failures_total{target="db:5432/tcp",ip="10.0.0.1"} 1` + "\n",
+		`portping_attempts_total{target="db:5432/tcp",ip="10.0.0.2"} 0` + "\n",
+		`portping_errors_total{target="db:5432/tcp",ip="10.0.0.1",class="timeout"} 1` + "\n",
+		`portping_up{target="db:5432/tcp",ip="10.0.0.1"} 0` + "\n",
+		"# TYPE portping_rtt_seconds histogram\n",
+		`portping_rtt_seconds_bucket{target="db:5432/tcp",ip="10.0.0.1",le="0.0025"} 0` + "\n",
+		`portping_rtt_seconds_bucket{target="db:5432/tcp",ip="10.0.0.1",le="0.005"} 1` + "\n",
+		`portping_rtt_seconds_bucket{target="db:5432/tcp",ip="10.0.0.1",le="0.025"} 2` + "\n",
+		`portping_rtt_seconds_bucket{target="db:5432/tcp",ip="10.0.0.1",le="+Inf"} 2` + "\n",
+		`portping_rtt_seconds_sum{target="db:5432/tcp",ip="10.0.0.1"} 0.023` + "\n",
+		`portping_rtt_seconds_count{target="db:5432/tcp",ip="10.0.0.1"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q\n%s", want, body)
+		}
+	}
+}
+
+// stalledWriter is a response writer whose first write blocks until
+// release is closed, like a scraper that stopped reading.
+type stalledWriter struct {
+	httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.writing)
+		<-w.release
+	})
+	return len(b), nil
+}
+
+func TestExporter_MetricsDoNotBlockResults(t *testing.T) {
+	e := New(nil)
+	cfg := &models.Config{Host: "db", Port: "5432", Proto: models.TCP, IPs: []models.IP{{IP: "10.0.0.1", IsIPv4: true}}}
+	e.OnStart(cfg)
+
+	w := &stalledWriter{ResponseRecorder: *httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	defer close(w.release)
+	go e.serveMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	<-w.writing
+
+	done := make(chan struct{})
+	go func() {
+		e.OnResult(cfg, models.Result{IP: cfg.IPs[0], Duration: time.Millisecond})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnResult blocked while /metrics was being written")
+	}
+}
+
+func TestExporter_Probe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+	_, open, _ := net.SplitHostPort(ln.Addr().String())
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	_ = closed.Close()
+
+	e := New(func(target, preset string) (*models.Config, error) {
+		if target == "" {
+			return nil, errors.New("target is required")
+		}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Config{
+			Host:       host,
+			Port:       port,
+			Proto:      models.TCP,
+			TimeoutDur: time.Second,
+			IPs:        []models.IP{{IP: host, IsIPv4: true}},
+		}, nil
+	})
+	srv := httptest.NewServer(e.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		want  []string
+	}{
+		{"open", "?target=127.0.0.1:" + open, http.StatusOK, []string{
+			"probe_success 1\n",
+			`portping_probe_success{ip="127.0.0.1"} 1` + "\n",
+			`portping_probe_rtt_seconds{ip="127.0.0.1"} `,
+			"probe_dns_lookup_time_seconds 0\n",
+		}},
+		{"closed", "?target=127.0.0.1:" + closedPort, http.StatusOK, []string{
+			"probe_success 0\n",
+			`portping_probe_success{ip="127.0.0.1"} 0` + "\n",
+		}},
+		{"no target", "", http.StatusBadRequest, []string{"target is required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := get(t, srv, "/probe"+tt.query)
+			if code != tt.code {
+				t.Fatalf("status = %d, want %d\n%s", code, tt.code, body)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("body missing %q\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{500 * time.Microsecond, 0},
+		{time.Millisecond, 0},
+		{2 * time.Millisecond, 1},
+		{10 * time.Second, len(Buckets) - 1},
+		{time.Minute, len(Buckets)},
+	}
+	for _, tt := range tests {
+		if got := bucket(tt.d); got != tt.want {
+			t.Errorf("bucket(%s) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
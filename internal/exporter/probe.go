@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"github.com/sopov/portping/internal/app"
+	"github.com/sopov/portping/internal/models"
+	"net/http"
+	"time"
+)
+
+// summary keeps the statistics of the single target of a one-shot probe.
+type summary struct {
+	cfg   *models.Config
+	stats map[string]*models.Stats
+}
+
+func (s *summary) OnStart(*models.Config)                 {}
+func (s *summary) OnResult(*models.Config, models.Result) {}
+func (s *summary) OnEvent(*models.Config, models.Event)   {}
+func (s *summary) OnSummary(cfg *models.Config, statsMap map[string]*models.Stats) {
+	s.cfg, s.stats = cfg, statsMap
+}
+
+// serveProbe runs a single attempt against every address of the target of
+// the request and reports the outcome. Invalid parameters and failed DNS
+// lookups are answered with 400 Bad Request.
+func (e *Exporter) serveProbe(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	q := r.URL.Query()
+	cfg, err := e.target(q.Get("target"), q.Get("preset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// one attempt per address, all addresses at once to fit the scrape
+	cfg.Count, cfg.Nonstop = 1, false
+	cfg.Wait, cfg.Deadline, cfg.Successes, cfg.FailStreak = "", 0, 0, 0
+	cfg.Reresolve = 0
+	cfg.Parallel = !cfg.HappyEyeballs
+
+	sum := &summary{}
+	if err := app.NewApp(r.Context(), cfg, sum).Run(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	duration := time.Since(start)
+
+	success := sum.cfg != nil
+	type row struct {
+		ip string
+		st *models.Stats
+	}
+	var rows []row
+	if success {
+		if cfg.HappyEyeballs {
+			success = sum.stats[models.RaceKey].Connects > 0
+		}
+		for _, ip := range cfg.IPs {
+			st := sum.stats[ip.IP]
+			if st == nil || st.Attempts == 0 {
+				continue
+			}
+			rows = append(rows, row{ip: ip.IP, st: st})
+			if !cfg.HappyEyeballs {
+				success = success && st.Connects > 0
+			}
+		}
+		success = success && len(rows) > 0
+	}
+	var lookup time.Duration
+	if cfg.Resolution != nil {
+		lookup = cfg.Resolution.Duration
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	m := &metricWriter{w: w}
+	m.header("probe_success", "gauge", "Whether every probed address of the target answered.")
+	m.sample("probe_success", nil, boolValue(success))
+	m.header("probe_duration_seconds", "gauge", "Duration of the probe, including the DNS lookup.")
+	m.sample("probe_duration_seconds", nil, duration.Seconds())
+	m.header("probe_dns_lookup_time_seconds", "gauge", "Duration of the DNS lookup, 0 for IP addresses.")
+	m.sample("probe_dns_lookup_time_seconds", nil, lookup.Seconds())
+	m.header("portping_probe_success", "gauge", "Whether the address answered.")
+	for _, r := range rows {
+		m.sample("portping_probe_success", []string{"ip", r.ip}, boolValue(r.st.Connects > 0))
+	}
+	m.header("portping_probe_rtt_seconds", "gauge", "Round-trip time of the successful attempt to the address.")
+	for _, r := range rows {
+		if r.st.Connects > 0 {
+			m.sample("portping_probe_rtt_seconds", []string{"ip", r.ip}, r.st.Total.Seconds())
+		}
+	}
+}
@@ -3,7 +3,9 @@ package helpers
 import (
 	"fmt"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,53 @@ func ValidPort(port string) bool {
 	return err == nil && i >= 1 && i <= 65535
 }
 
+// ParsePorts expands a port list like "22,80,8000-8100" into sorted,
+// unique port numbers.
+func ParsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, item := range strings.Split(spec, ",") {
+		lo, hi, isRange := strings.Cut(item, "-")
+		if !isRange {
+			hi = lo
+		}
+		if !ValidPort(lo) || !ValidPort(hi) {
+			return nil, fmt.Errorf("invalid port `%s`", item)
+		}
+		from, _ := strconv.Atoi(lo)
+		to, _ := strconv.Atoi(hi)
+		if from > to {
+			return nil, fmt.Errorf("invalid port range `%s`", item)
+		}
+		for p := from; p <= to; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// PortRanges compacts sorted ports back into "22, 80, 8000-8100" form.
+func PortRanges(ports []int) string {
+	var parts []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		part := strconv.Itoa(ports[i])
+		if j > i {
+			part += "-" + strconv.Itoa(ports[j])
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
 func IsWindows() bool {
 	return runtime.GOOS == "windows"
 }
@@ -64,3 +64,29 @@ func TestIsWindows(_ *testing.T) {
 	result := IsWindows()
 	_ = result
 }
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"80", "80", false},
+		{"443,22,80", "22, 80, 443", false},
+		{"8000-8003,22,8002", "22, 8000-8003", false},
+		{"1-1", "1", false},
+		{"0-10", "", true},
+		{"100-90", "", true},
+		{"22,,80", "", true},
+		{"http", "", true},
+	}
+	for _, tt := range tests {
+		ports, err := ParsePorts(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParsePorts(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+		if got := PortRanges(ports); !tt.wantErr && got != tt.want {
+			t.Errorf("ParsePorts(%q) = %q, expected %q", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,12 @@ package models
 
 import (
 	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -9,11 +15,95 @@ type Proto string
 
 const TCP Proto = "tcp"
 const UDP Proto = "udp"
+const HTTP Proto = "http"
 
 func (proto Proto) String() string {
 	return string(proto)
 }
 
+type Output string
+
+const (
+	OutputText   Output = "text"
+	OutputJSON   Output = "json"
+	OutputNDJSON Output = "ndjson"
+	OutputCSV    Output = "csv"
+	OutputNagios Output = "nagios"
+)
+
+func (o Output) String() string {
+	return string(o)
+}
+
+type ErrClass string
+
+const (
+	ErrNone            ErrClass = ""
+	ErrTimeout         ErrClass = "timeout"
+	ErrRefused         ErrClass = "refused"
+	ErrPortUnreachable ErrClass = "port-unreachable"
+	ErrHostUnreachable ErrClass = "host-unreachable"
+	ErrNetUnreachable  ErrClass = "net-unreachable"
+	ErrDNS             ErrClass = "dns"
+	ErrReset           ErrClass = "reset"
+	ErrCanceled        ErrClass = "canceled"
+	ErrValidation      ErrClass = "validation"
+	ErrBanner          ErrClass = "banner"
+	ErrOther           ErrClass = "other"
+)
+
+// ErrClasses lists the failure categories in display order.
+var ErrClasses = []ErrClass{
+	ErrTimeout,
+	ErrRefused,
+	ErrPortUnreachable,
+	ErrHostUnreachable,
+	ErrNetUnreachable,
+	ErrDNS,
+	ErrReset,
+	ErrValidation,
+	ErrBanner,
+	ErrCanceled,
+	ErrOther,
+}
+
+func (c ErrClass) String() string {
+	return string(c)
+}
+
+// PortState is the nmap-style state of a port derived from a probe.
+type PortState string
+
+const (
+	StateOpen         PortState = "open"
+	StateClosed       PortState = "closed"
+	StateFiltered     PortState = "filtered"
+	StateOpenFiltered PortState = "open|filtered"
+)
+
+// PortStates lists the port states in display order.
+var PortStates = []PortState{StateOpen, StateClosed, StateFiltered, StateOpenFiltered}
+
+func (s PortState) String() string {
+	return string(s)
+}
+
+// OutputSpec is a single report sink: a format and an optional file path.
+// An empty Path means stdout.
+type OutputSpec struct {
+	Format Output
+	Path   string
+}
+
+// Listen is the config of "portping listen", the echo reflector. An empty
+// address disables the protocol.
+type Listen struct {
+	UDP string
+	TCP string
+	// Timestamp stamps portping echo packets with the reflector times.
+	Timestamp bool
+}
+
 type IP struct {
 	IP     string
 	IsIPv4 bool
@@ -23,27 +113,192 @@ func (ip IP) IsIPv6() bool {
 	return !ip.IsIPv4
 }
 
+func (ip IP) Family() string {
+	if ip.IsIPv4 {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// StatusRange is an inclusive range of accepted HTTP status codes.
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// HTTPCheck configures the HTTP(S) request probe.
+type HTTPCheck struct {
+	Method  string
+	Path    string
+	Headers http.Header
+	Status  []StatusRange
+	Body    *regexp.Regexp
+}
+
+func (h HTTPCheck) StatusOK(code int) bool {
+	for _, r := range h.Status {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
 type Config struct {
-	Proto         Proto
-	Host          string
-	Port          string
-	Timeout       int
-	TimeoutDur    time.Duration
-	Delay         int
-	DelayDur      time.Duration
-	Count         int
-	Nonstop       bool
-	AllowIPv4     bool
-	AllowIPv6     bool
+	Proto      Proto
+	Host       string
+	Port       string
+	Timeout    int
+	TimeoutDur time.Duration
+	Delay      int
+	DelayDur   time.Duration
+	Count      int
+	Nonstop    bool
+	AllowIPv4  bool
+	AllowIPv6  bool
+	// Dual requires and compares both address families.
+	Dual          bool
 	NoColor       bool
 	IPs           []IP
 	Preset        string
 	UDPPayloadHex string
 	UDPPayload    []byte
+	Outputs       []OutputSpec
+	Verbose       bool
+	TLS           bool
+	TLSInsecure   bool
+	TLSCAFile     string
+	TLSRootCAs    *x509.CertPool
+	CertWarnDays  int
+	HTTP          HTTPCheck
+	Banner        bool
+	Expect        *regexp.Regexp
+	// Ports holds the expanded port list when Port is a range or list;
+	// such a target is swept once instead of pinged continuously.
+	Ports []int
+	// Workers caps the number of concurrent probes.
+	Workers int
+	// Parallel probes all IPs of a round concurrently.
+	Parallel bool
+	// HappyEyeballs races all IPs in every attempt as RFC 8305 clients do.
+	HappyEyeballs bool
+	// TWAMP runs a two-way echo session per IP over a single UDP socket.
+	TWAMP bool
+	// Source, SourcePort and Interface bind the probes to a local address,
+	// port and network interface (Linux only); zero values leave the
+	// choice to the system.
+	Source     string
+	SourcePort int
+	Interface  string
+	// Reresolve is the interval of repeated DNS lookups, 0 disables them.
+	Reresolve time.Duration
+	// Wait ends the session as soon as every target met the condition.
+	Wait WaitFor
+	// Deadline bounds the duration of the whole session, 0 disables it.
+	Deadline time.Duration
+	// Successes stops a target after that many successful attempts and
+	// FailStreak after that many consecutive failures; 0 disables them.
+	Successes  int
+	FailStreak int
+	// Stop is set by the session to the condition that ended the target.
+	Stop StopReason
+	// Limits are checked against the statistics of the finished session.
+	Limits Limits
+	// Resolver is the "host:port" of the DNS server used for lookups,
+	// empty for the system resolver. ResolverTCP queries it over TCP only.
+	Resolver    string
+	ResolverTCP bool
+	// Resolution describes the lookup of Host, nil for IP literals.
+	Resolution *Resolution
+	// SRV is the record the target was discovered from, if any.
+	SRV *SRV
+	// Targets holds one complete config per destination when several
+	// destinations are pinged in one session.
+	Targets []*Config
+}
+
+func (c *Config) IsUDP() bool  { return c.Proto == UDP }
+func (c *Config) IsTCP() bool  { return c.Proto == TCP }
+func (c *Config) IsHTTP() bool { return c.Proto == HTTP }
+
+// IsSweep reports whether the target is a port range or list.
+func (c *Config) IsSweep() bool { return len(c.Ports) > 0 }
+
+// TargetList returns the destinations of the session, which is the config
+// itself unless several targets were given.
+func (c *Config) TargetList() []*Config {
+	if len(c.Targets) == 0 {
+		return []*Config{c}
+	}
+	return c.Targets
+}
+
+// Name identifies the target in combined output, e.g. "example.com:443/tcp".
+func (c *Config) Name() string {
+	return net.JoinHostPort(c.Host, c.Port) + "/" + c.Proto.String()
+}
+
+// SourceName describes the local binding of the probes, e.g.
+// "10.0.0.2:40000 dev eth1", or is empty when there is none.
+func (c *Config) SourceName() string {
+	if c.Source == "" && c.SourcePort == 0 && c.Interface == "" {
+		return ""
+	}
+	name := c.Source
+	if name == "" {
+		name = "*"
+	}
+	if c.SourcePort > 0 {
+		name = net.JoinHostPort(name, strconv.Itoa(c.SourcePort))
+	}
+	if c.Interface != "" {
+		name += " dev " + c.Interface
+	}
+	return name
+}
+
+// URL returns the request URL of the HTTP probe.
+func (c *Config) URL() string {
+	scheme, defPort := "http", "80"
+	if c.TLS {
+		scheme, defPort = "https", "443"
+	}
+	host := net.JoinHostPort(c.Host, c.Port)
+	if c.Port == defPort {
+		host = c.Host
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+	path := c.HTTP.Path
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
+
+// Resolution describes a DNS lookup of the destination.
+type Resolution struct {
+	// Server is the DNS server that was asked, empty for the system resolver.
+	Server string
+	// Duration is the time of the lookup, including the separate CNAME
+	// query made through the system resolver.
+	Duration time.Duration
+	// CNAMEs is the alias chain followed from the host to its canonical name.
+	CNAMEs []string
+}
+
+// SRV identifies the DNS SRV record behind a target.
+type SRV struct {
+	// Name is the SRV owner name, e.g. "_postgres._tcp.db.internal".
+	Name     string
+	Priority uint16
+	Weight   uint16
 }
 
-func (c *Config) IsUDP() bool { return c.Proto == UDP }
-func (c *Config) IsTCP() bool { return c.Proto == TCP }
+// RaceKey is the stats map key of the Happy Eyeballs race totals; the
+// per-IP entries of a race session count the attempts an address won.
+const RaceKey = "race"
 
 type Stats struct {
 	IP       IP
@@ -53,6 +308,197 @@ type Stats struct {
 	Minimum  time.Duration
 	Maximum  time.Duration
 	Total    time.Duration
+	// SumSquares is the sum of squared RTTs in ns², used for mdev.
+	SumSquares float64
+	// Jitter is the RFC 3550 smoothed inter-arrival jitter.
+	Jitter time.Duration
+	// Last is the RTT of the previous successful attempt.
+	Last time.Duration
+	// Samples holds successful RTTs, reservoir-sampled once full.
+	Samples []time.Duration
+	// Errors counts failures per category.
+	Errors map[ErrClass]int
+	// States counts attempts per port state and Ports keeps the last
+	// state of every probed port; both are only set when states are known.
+	States map[PortState]int
+	Ports  map[string]PortState
+	// Retired is set once the IP vanished from the DNS answer.
+	Retired bool
+	// Seq holds the counters of a two-way echo session.
+	Seq *SeqStats
+}
+
+// SeqStats are the sequence-aware counters of a two-way echo session.
+// Forward and reverse loss need a reflector that numbers the packets it
+// receives; losses after its last numbered reply count as Unknown.
+type SeqStats struct {
+	Sent        int
+	Received    int // distinct replies, including late ones
+	Reflected   int // packets received by the reflector
+	ForwardLoss int
+	ReverseLoss int
+	Unknown     int
+	Reordered   int
+	Duplicates  int
+	Late        int // replies after their attempt timed out
+	// ForwardJitter and ReverseJitter are the RFC 3550 jitter of the
+	// one-way delays; clock offsets cancel out.
+	ForwardJitter time.Duration
+	ReverseJitter time.Duration
+}
+
+// TLSInfo describes a completed TLS handshake.
+type TLSInfo struct {
+	Handshake   time.Duration
+	Version     string
+	CipherSuite string
+	ALPN        string
+	Subject     string
+	NotAfter    time.Time
+}
+
+// HTTPInfo describes the response of an HTTP(S) probe.
+type HTTPInfo struct {
+	Status int
+	Proto  string
+	TTFB   time.Duration
+	Bytes  int64
+}
+
+// UDPInfo describes a UDP reply and what its preset validator decoded.
+type UDPInfo struct {
+	Bytes   int
+	RCode   string        // DNS response code
+	Answers int           // DNS answer count
+	Stratum int           // NTP stratum
+	Offset  time.Duration // NTP server clock offset
+	Mapped  string        // STUN XOR-MAPPED-ADDRESS
+	// Forward and Reverse are the one-way delays to and from a stamping
+	// echo reflector; they are only meaningful with synchronized clocks.
+	Forward time.Duration
+	Reverse time.Duration
+	// Stamped is set when the echo reflector stamped the reply.
+	Stamped bool
+}
+
+// Details carries protocol-specific data collected by a probe on top of
+// the total duration.
+type Details struct {
+	Connect time.Duration
+	TLS     *TLSInfo
+	HTTP    *HTTPInfo
+	UDP     *UDPInfo
+	Banner  string
+	Warning string
+}
+
+// Result is the outcome of a single ping attempt.
+type Result struct {
+	Attempt  int
+	Sub      int
+	IP       IP
+	Port     string
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+	ErrClass ErrClass
+	// State is set when the probe determines a port state, e.g. in sweeps.
+	State   PortState
+	Details *Details
+}
+
+// WaitFor is the condition of a wait-for session.
+type WaitFor string
+
+const (
+	// WaitUp waits for the first successful attempt.
+	WaitUp WaitFor = "up"
+	// WaitDown waits for the first failed attempt.
+	WaitDown WaitFor = "down"
+)
+
+// StopReason names the condition that ended the pings of a target.
+type StopReason string
+
+const (
+	StopCount       StopReason = "count"
+	StopDeadline    StopReason = "deadline"
+	StopSuccesses   StopReason = "successes"
+	StopFailures    StopReason = "failures"
+	StopWait        StopReason = "wait"
+	StopInterrupted StopReason = "interrupted"
+)
+
+// Status is the outcome of checking a session against its Limits, in
+// increasing severity. The values are the plugin states of Nagios.
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusWarning
+	StatusCritical
+	StatusUnknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusWarning:
+		return "WARNING"
+	case StatusCritical:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+// Limit is the warning and critical threshold of a metric; a value above
+// a set threshold violates it.
+type Limit struct {
+	Warn, Crit       float64
+	HasWarn, HasCrit bool
+}
+
+func (l Limit) IsSet() bool { return l.HasWarn || l.HasCrit }
+
+// Check returns the status of value v.
+func (l Limit) Check(v float64) Status {
+	switch {
+	case l.HasCrit && v > l.Crit:
+		return StatusCritical
+	case l.HasWarn && v > l.Warn:
+		return StatusWarning
+	}
+	return StatusOK
+}
+
+// Limits are the thresholds of a session: Loss in percent, Avg and P95 in
+// milliseconds.
+type Limits struct {
+	Loss Limit
+	Avg  Limit
+	P95  Limit
+}
+
+func (l Limits) IsSet() bool { return l.Loss.IsSet() || l.Avg.IsSet() || l.P95.IsSet() }
+
+// EventKind names a session event that is not an attempt.
+type EventKind string
+
+const EventResolve EventKind = "resolve"
+
+func (k EventKind) String() string {
+	return string(k)
+}
+
+// Event reports a change during the session, such as a repeated DNS
+// lookup that added or removed IPs or failed.
+type Event struct {
+	Kind    EventKind
+	Time    time.Time
+	Added   []IP
+	Removed []IP
+	Err     error
 }
 
 type PingOptions struct {
@@ -60,4 +506,9 @@ type PingOptions struct {
 	Config  *Config
 	Address string
 	Payload []byte
+	// Dialer, when set, opens the connections of the probe, e.g. from a
+	// source address; a plain dialer is used otherwise.
+	Dialer *net.Dialer
+	// Details, when set, is filled by the probe.
+	Details *Details
 }
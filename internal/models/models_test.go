@@ -26,3 +26,35 @@ func TestProto_String(t *testing.T) {
 	}
 }
 
+func TestConfig_URL(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		expected string
+	}{
+		{"Default path", Config{Host: "example.com", Port: "80"}, "http://example.com/"},
+		{"Custom port", Config{Host: "example.com", Port: "8080", HTTP: HTTPCheck{Path: "/health"}}, "http://example.com:8080/health"},
+		{"HTTPS default port", Config{Host: "example.com", Port: "443", TLS: true}, "https://example.com/"},
+		{"HTTPS custom port", Config{Host: "example.com", Port: "80", TLS: true}, "https://example.com:80/"},
+		{"IPv6", Config{Host: "::1", Port: "80"}, "http://[::1]/"},
+		{"IPv6 with port", Config{Host: "::1", Port: "8080"}, "http://[::1]:8080/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.URL(); got != tt.expected {
+				t.Errorf("Config.URL() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHTTPCheck_StatusOK(t *testing.T) {
+	check := HTTPCheck{Status: []StatusRange{{Min: 200, Max: 299}, {Min: 304, Max: 304}}}
+
+	for code, expected := range map[int]bool{200: true, 299: true, 304: true, 301: false, 500: false} {
+		if got := check.StatusOK(code); got != expected {
+			t.Errorf("StatusOK(%d) = %v, expected %v", code, got, expected)
+		}
+	}
+}
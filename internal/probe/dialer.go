@@ -0,0 +1,56 @@
+package probe
+
+import (
+	"context"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"strings"
+)
+
+// NewDialer returns the dialer of the probes of cfg, bound to the source
+// address and port and, on Linux, to the interface of the config.
+func NewDialer(cfg *models.Config) *net.Dialer {
+	d := &net.Dialer{Control: control(cfg)}
+	if cfg.Source == "" && cfg.SourcePort == 0 {
+		return d
+	}
+	ip := net.ParseIP(cfg.Source) // nil binds any address
+	if cfg.IsUDP() {
+		d.LocalAddr = &net.UDPAddr{IP: ip, Port: cfg.SourcePort}
+	} else {
+		d.LocalAddr = &net.TCPAddr{IP: ip, Port: cfg.SourcePort}
+	}
+	return d
+}
+
+// newDNSDialer returns the dialer of the DNS queries of cfg over network,
+// bound like the probes but to an ephemeral port: a fixed -sport would
+// collide with the probes themselves.
+func newDNSDialer(cfg *models.Config, network string) *net.Dialer {
+	dnsCfg := *cfg
+	dnsCfg.SourcePort = 0
+	dnsCfg.Proto = models.UDP
+	if strings.HasPrefix(network, models.TCP.String()) {
+		dnsCfg.Proto = models.TCP
+	}
+	return NewDialer(&dnsCfg)
+}
+
+// dial connects to address with the dialer of opts, a plain dialer when
+// it has none.
+func dial(ctx context.Context, opts models.PingOptions, network, address string) (net.Conn, error) {
+	d := opts.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok && opts.Config.SourcePort > 0 {
+		// close with a reset, a TIME_WAIT would hold the source port and
+		// fail the next attempt
+		_ = tc.SetLinger(0)
+	}
+	return conn, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package probe
+
+import (
+	"github.com/sopov/portping/internal/models"
+	"syscall"
+)
+
+// BindToDeviceSupported reports whether probes can be bound to an
+// interface (SO_BINDTODEVICE).
+const BindToDeviceSupported = true
+
+// control returns the socket setup of the probes of cfg: SO_REUSEADDR for
+// a fixed source port, which concurrent probes share, and SO_BINDTODEVICE
+// for an interface. It returns nil when there is nothing to set.
+func control(cfg *models.Config) func(network, address string, c syscall.RawConn) error {
+	if cfg.SourcePort == 0 && cfg.Interface == "" {
+		return nil
+	}
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		ctrlErr := c.Control(func(fd uintptr) {
+			if cfg.SourcePort > 0 {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			}
+			if err == nil && cfg.Interface != "" {
+				err = syscall.BindToDevice(int(fd), cfg.Interface)
+			}
+		})
+		if ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package probe
+
+import (
+	"github.com/sopov/portping/internal/models"
+	"syscall"
+)
+
+// BindToDeviceSupported reports whether probes can be bound to an
+// interface (SO_BINDTODEVICE).
+const BindToDeviceSupported = false
+
+// control returns nil: the interface is validated on Linux only and the
+// source port is not shared without SO_REUSEADDR.
+func control(*models.Config) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
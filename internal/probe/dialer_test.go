@@ -0,0 +1,151 @@
+package probe
+
+import (
+	"context"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freePort returns a local port of network that is not in use.
+func freePort(t *testing.T, network string) int {
+	t.Helper()
+	var addr net.Addr
+	if network == "udp" {
+		c, err := net.ListenPacket(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = c.LocalAddr()
+		_ = c.Close()
+	} else {
+		ln, err := net.Listen(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr()
+		_ = ln.Close()
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func TestPingTCP_Source(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	peers := make(chan string, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			peers <- c.RemoteAddr().String()
+			_ = c.Close()
+		}
+	}()
+
+	cfg := &models.Config{
+		Proto:      models.TCP,
+		TimeoutDur: time.Second,
+		Source:     "127.0.0.1",
+		SourcePort: freePort(t, "tcp"),
+	}
+	want := net.JoinHostPort(cfg.Source, strconv.Itoa(cfg.SourcePort))
+	// the second attempt reuses the source port of the first
+	for i := range 2 {
+		opts := models.PingOptions{
+			Context: context.Background(),
+			Config:  cfg,
+			Address: ln.Addr().String(),
+			Dialer:  NewDialer(cfg),
+		}
+		if _, err := PingTCP(opts); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+		if got := <-peers; got != want {
+			t.Errorf("attempt %d: peer = %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestPingUDP_Source(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	peers := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			peers <- addr.String()
+			_, _ = conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	cfg := &models.Config{
+		Proto:      models.UDP,
+		TimeoutDur: time.Second,
+		Source:     "127.0.0.1",
+		SourcePort: freePort(t, "udp"),
+	}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  cfg,
+		Address: conn.LocalAddr().String(),
+		Payload: []byte("ping"),
+		Dialer:  NewDialer(cfg),
+	}
+	if _, err := PingUDP(opts); err != nil {
+		t.Fatal(err)
+	}
+	want := net.JoinHostPort(cfg.Source, strconv.Itoa(cfg.SourcePort))
+	if got := <-peers; got != want {
+		t.Errorf("peer = %s, want %s", got, want)
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	if d := NewDialer(&models.Config{Proto: models.TCP}); d.LocalAddr != nil {
+		t.Errorf("LocalAddr = %v, want none", d.LocalAddr)
+	}
+	d := NewDialer(&models.Config{Proto: models.UDP, Source: "::1"})
+	if a, ok := d.LocalAddr.(*net.UDPAddr); !ok || !a.IP.Equal(net.IPv6loopback) || a.Port != 0 {
+		t.Errorf("LocalAddr = %#v, want [::1]:0/udp", d.LocalAddr)
+	}
+	d = NewDialer(&models.Config{Proto: models.HTTP, SourcePort: 40000})
+	if a, ok := d.LocalAddr.(*net.TCPAddr); !ok || a.IP != nil || a.Port != 40000 {
+		t.Errorf("LocalAddr = %#v, want :40000/tcp", d.LocalAddr)
+	}
+}
+
+func TestNewDNSDialer(t *testing.T) {
+	cfg := &models.Config{Proto: models.TCP, Source: "192.0.2.10", SourcePort: 40000}
+	tests := []struct {
+		network string
+		want    net.Addr
+	}{
+		{"udp", &net.UDPAddr{IP: net.ParseIP("192.0.2.10")}},
+		{"tcp4", &net.TCPAddr{IP: net.ParseIP("192.0.2.10")}},
+	}
+	for _, tt := range tests {
+		if got := newDNSDialer(cfg, tt.network).LocalAddr; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("newDNSDialer(%q).LocalAddr = %v, expected %v", tt.network, got, tt.want)
+		}
+	}
+	if cfg.SourcePort != 40000 || cfg.Proto != models.TCP {
+		t.Errorf("newDNSDialer() changed the config: %+v", cfg)
+	}
+}
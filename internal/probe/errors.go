@@ -0,0 +1,74 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"syscall"
+)
+
+// ClassifyError maps a ping error to a failure category.
+func ClassifyError(err error) models.ErrClass {
+	if err == nil {
+		return models.ErrNone
+	}
+	var valErr *ValidationError
+	if errors.As(err, &valErr) {
+		return models.ErrValidation
+	}
+	var bannerErr *BannerError
+	if errors.As(err, &bannerErr) {
+		return models.ErrBanner
+	}
+	if errors.Is(err, context.Canceled) {
+		return models.ErrCanceled
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return models.ErrDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// on a connected UDP socket this is an ICMP port unreachable
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Net == models.UDP.String() {
+			return models.ErrPortUnreachable
+		}
+		return models.ErrRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) {
+		return models.ErrReset
+	}
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.EHOSTDOWN) {
+		return models.ErrHostUnreachable
+	}
+	if errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.ENETDOWN) {
+		return models.ErrNetUnreachable
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ETIMEDOUT) {
+		return models.ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return models.ErrTimeout
+	}
+	return models.ErrOther
+}
+
+// PortState derives the port state from the failure category of a probe,
+// ErrNone meaning success. A UDP port that does not answer may be open or
+// filtered, a TCP port that does not answer is filtered.
+func PortState(proto models.Proto, class models.ErrClass) models.PortState {
+	switch class {
+	case models.ErrNone, models.ErrValidation, models.ErrBanner:
+		// the port answered, even if not as expected
+		return models.StateOpen
+	case models.ErrRefused, models.ErrReset, models.ErrPortUnreachable:
+		return models.StateClosed
+	case models.ErrTimeout:
+		if proto == models.UDP {
+			return models.StateOpenFiltered
+		}
+	}
+	return models.StateFiltered
+}
@@ -0,0 +1,67 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected models.ErrClass
+	}{
+		{"Nil", nil, models.ErrNone},
+		{"Canceled", context.Canceled, models.ErrCanceled},
+		{"Deadline", context.DeadlineExceeded, models.ErrTimeout},
+		{"Net timeout", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, models.ErrTimeout},
+		{"Refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, models.ErrRefused},
+		{"Wrapped refused", fmt.Errorf("ping: %w", syscall.ECONNREFUSED), models.ErrRefused},
+		{"UDP port unreachable", &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}, models.ErrPortUnreachable},
+		{"Host unreachable", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, models.ErrHostUnreachable},
+		{"Network unreachable", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, models.ErrNetUnreachable},
+		{"Reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, models.ErrReset},
+		{"DNS", &net.DNSError{Err: "no such host", Name: "invalid.example", IsNotFound: true}, models.ErrDNS},
+		{"DNS timeout", &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, models.ErrDNS},
+		{"Validation", validationErrorf("dns: SERVFAIL"), models.ErrValidation},
+		{"Banner", &BannerError{Msg: "no banner received"}, models.ErrBanner},
+		{"Other", errors.New("boom"), models.ErrOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.expected {
+				t.Errorf("ClassifyError(%v) = %q, expected %q", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPortState(t *testing.T) {
+	tests := []struct {
+		proto    models.Proto
+		class    models.ErrClass
+		expected models.PortState
+	}{
+		{models.TCP, models.ErrNone, models.StateOpen},
+		{models.TCP, models.ErrBanner, models.StateOpen},
+		{models.TCP, models.ErrRefused, models.StateClosed},
+		{models.TCP, models.ErrTimeout, models.StateFiltered},
+		{models.TCP, models.ErrHostUnreachable, models.StateFiltered},
+		{models.UDP, models.ErrNone, models.StateOpen},
+		{models.UDP, models.ErrPortUnreachable, models.StateClosed},
+		{models.UDP, models.ErrTimeout, models.StateOpenFiltered},
+	}
+
+	for _, tt := range tests {
+		if got := PortState(tt.proto, tt.class); got != tt.expected {
+			t.Errorf("PortState(%s, %q) = %q, expected %q", tt.proto, tt.class, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"time"
+)
+
+// ConnectionAttemptDelay is the RFC 8305 delay between starting connection
+// attempts to successive addresses.
+const ConnectionAttemptDelay = 250 * time.Millisecond
+
+// InterleaveFamilies orders addresses for Happy Eyeballs (RFC 8305,
+// section 4): alternating families, starting with IPv6.
+func InterleaveFamilies(ips []models.IP) []models.IP {
+	var v4, v6 []models.IP
+	for _, ip := range ips {
+		if ip.IsIPv4 {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	out := make([]models.IP, 0, len(ips))
+	for i := 0; i < max(len(v4), len(v6)); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+// PingHappyEyeballs races TCP connections to ips in order. A new attempt
+// starts every ConnectionAttemptDelay, or right away when the previous one
+// fails; the first established connection wins and the others are
+// abandoned. It returns the winning address and the time until the winner
+// connected, or the first address and its error when all attempts fail.
+func PingHappyEyeballs(opts models.PingOptions, ips []models.IP) (models.IP, time.Duration, error) {
+	if len(ips) == 0 {
+		return models.IP{}, 0, errors.New("no addresses to connect to")
+	}
+	ctx, cancel := context.WithCancel(opts.Context)
+	defer cancel()
+
+	type outcome struct {
+		ip   models.IP
+		conn net.Conn
+		err  error
+	}
+	outcomes := make(chan outcome, len(ips))
+	stagger := time.NewTimer(ConnectionAttemptDelay)
+	defer stagger.Stop()
+
+	start := time.Now()
+	next, pending := 0, 0
+	errs := make(map[string]error, len(ips))
+	launch := func() {
+		ip := ips[next]
+		next++
+		pending++
+		stagger.Reset(ConnectionAttemptDelay)
+		go func() {
+			conn, err := dial(ctx, opts, models.TCP.String(), net.JoinHostPort(ip.IP, opts.Config.Port))
+			outcomes <- outcome{ip: ip, conn: conn, err: err}
+		}()
+	}
+
+	launch()
+	for pending > 0 {
+		select {
+		case o := <-outcomes:
+			pending--
+			if o.err == nil {
+				elapsed := time.Since(start)
+				_ = o.conn.Close()
+				cancel()
+				// close connections of losers that still complete
+				go func(n int) {
+					for range n {
+						if o := <-outcomes; o.conn != nil {
+							_ = o.conn.Close()
+						}
+					}
+				}(pending)
+				if opts.Details != nil {
+					opts.Details.Connect = elapsed
+				}
+				return o.ip, elapsed, nil
+			}
+			errs[o.ip.IP] = o.err
+			if next < len(ips) {
+				launch()
+			}
+		case <-stagger.C:
+			if next < len(ips) {
+				launch()
+			}
+		}
+	}
+	return ips[0], time.Since(start), errs[ips[0].IP]
+}
@@ -0,0 +1,74 @@
+package probe
+
+import (
+	"context"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []models.IP{
+		{IP: "192.0.2.1", IsIPv4: true},
+		{IP: "192.0.2.2", IsIPv4: true},
+		{IP: "192.0.2.3", IsIPv4: true},
+		{IP: "2001:db8::1"},
+	}
+	var got []string
+	for _, ip := range InterleaveFamilies(ips) {
+		got = append(got, ip.IP)
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	if len(got) != len(want) {
+		t.Fatalf("InterleaveFamilies() = %v, expected %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("InterleaveFamilies() = %v, expected %v", got, want)
+		}
+	}
+}
+
+func TestPingHappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	opts := models.PingOptions{
+		Context: ctx,
+		Config:  &models.Config{Proto: models.TCP, Port: port},
+		Details: &models.Details{},
+	}
+	// nothing listens on ::1, so the IPv4 attempt starts without waiting
+	// for the attempt delay and wins
+	ips := []models.IP{{IP: "::1"}, {IP: "127.0.0.1", IsIPv4: true}}
+
+	winner, d, err := PingHappyEyeballs(opts, ips)
+	if err != nil {
+		t.Fatalf("PingHappyEyeballs() error = %v", err)
+	}
+	if winner.IP != "127.0.0.1" {
+		t.Errorf("winner = %s, expected 127.0.0.1", winner.IP)
+	}
+	if d >= ConnectionAttemptDelay {
+		t.Errorf("duration = %v, a failed attempt should start the next one immediately", d)
+	}
+	if opts.Details.Connect != d {
+		t.Errorf("details connect = %v, expected %v", opts.Details.Connect, d)
+	}
+
+	_ = ln.Close()
+	winner, _, err = PingHappyEyeballs(opts, ips)
+	if err == nil {
+		t.Fatal("expected error when all attempts fail")
+	}
+	if winner.IP != "::1" || ClassifyError(err) != models.ErrRefused {
+		t.Errorf("expected refused error of the first address, got %s: %v", winner.IP, err)
+	}
+}
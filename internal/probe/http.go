@@ -0,0 +1,105 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/sopov/portping/internal/models"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// maxHTTPBody limits how much of a response body is read for matching.
+const maxHTTPBody = 1 << 20
+
+// PingHTTP sends a single HTTP(S) request to opts.Address, using the
+// configured host for the URL, Host header and SNI. The attempt fails when
+// the status code or body does not match the expectations in cfg.HTTP.
+func PingHTTP(opts models.PingOptions) (time.Duration, error) {
+	cfg := opts.Config
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dial(ctx, opts, network, opts.Address)
+		},
+		TLSClientConfig:   tlsConfig(cfg),
+		ForceAttemptHTTP2: true,
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	method := cfg.HTTP.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(opts.Context, method, cfg.URL(), nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range cfg.HTTP.Headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if host := cfg.HTTP.Headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	var connectStart, connected, tlsStart, tlsDone, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { connected = time.Now() },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+
+	if opts.Details != nil {
+		if !connected.IsZero() {
+			opts.Details.Connect = connected.Sub(connectStart)
+		}
+		if resp.TLS != nil && !tlsDone.IsZero() {
+			opts.Details.TLS = tlsInfo(*resp.TLS, tlsDone.Sub(tlsStart))
+			opts.Details.Warning = certWarning(cfg, opts.Details.TLS, time.Now())
+		}
+		info := &models.HTTPInfo{
+			Status: resp.StatusCode,
+			Proto:  resp.Proto,
+			Bytes:  int64(len(body)),
+		}
+		if !firstByte.IsZero() {
+			info.TTFB = firstByte.Sub(start)
+		}
+		opts.Details.HTTP = info
+	}
+
+	if len(cfg.HTTP.Status) > 0 && !cfg.HTTP.StatusOK(resp.StatusCode) {
+		return elapsed, validationErrorf("unexpected status %s", resp.Status)
+	}
+	if cfg.HTTP.Body != nil && !cfg.HTTP.Body.Match(body) {
+		return elapsed, validationErrorf("body does not match `%s`", cfg.HTTP.Body)
+	}
+
+	return elapsed, nil
+}
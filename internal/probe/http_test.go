@@ -0,0 +1,147 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newHTTPServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if host, _, _ := net.SplitHostPort(r.Host); host != "svc.example" || r.Header.Get("X-Probe") != "portping" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"ok"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func httpTestConfig(t *testing.T, srv *httptest.Server, path string) *models.Config {
+	t.Helper()
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Config{
+		Proto:      models.HTTP,
+		Host:       "svc.example",
+		Port:       port,
+		TimeoutDur: 2 * time.Second,
+		HTTP: models.HTTPCheck{
+			Method:  http.MethodGet,
+			Path:    path,
+			Headers: http.Header{"X-Probe": []string{"portping"}},
+			Status:  []models.StatusRange{{Min: 200, Max: 299}},
+		},
+	}
+}
+
+func TestPingHTTP(t *testing.T) {
+	srv := newHTTPServer(t)
+	cfg := httpTestConfig(t, srv, "/health")
+	cfg.HTTP.Body = regexp.MustCompile(`"status":"ok"`)
+
+	details := &models.Details{}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  cfg,
+		Address: srv.Listener.Addr().String(),
+		Details: details,
+	}
+
+	duration, err := PingHTTP(opts)
+	if err != nil {
+		t.Fatalf("PingHTTP() error = %v, expected nil", err)
+	}
+	if details.HTTP == nil {
+		t.Fatal("PingHTTP() did not fill HTTP details")
+	}
+	if details.HTTP.Status != http.StatusOK {
+		t.Errorf("PingHTTP() status = %d, expected 200", details.HTTP.Status)
+	}
+	if details.HTTP.TTFB <= 0 || details.HTTP.TTFB > duration {
+		t.Errorf("PingHTTP() ttfb = %v, expected in (0, %v]", details.HTTP.TTFB, duration)
+	}
+	if details.HTTP.Bytes != int64(len(`{"status":"ok"}`)) {
+		t.Errorf("PingHTTP() bytes = %d", details.HTTP.Bytes)
+	}
+}
+
+func TestPingHTTP_Expectations(t *testing.T) {
+	srv := newHTTPServer(t)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"Unexpected status", "/missing", ""},
+		{"Body mismatch", "/health", `"status":"degraded"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := httpTestConfig(t, srv, tt.path)
+			if tt.body != "" {
+				cfg.HTTP.Body = regexp.MustCompile(tt.body)
+			}
+			opts := models.PingOptions{
+				Context: context.Background(),
+				Config:  cfg,
+				Address: srv.Listener.Addr().String(),
+			}
+
+			_, err := PingHTTP(opts)
+			var valErr *ValidationError
+			if !errors.As(err, &valErr) {
+				t.Fatalf("PingHTTP() error = %v, expected ValidationError", err)
+			}
+			if ClassifyError(err) != models.ErrValidation {
+				t.Errorf("ClassifyError() = %q, expected %q", ClassifyError(err), models.ErrValidation)
+			}
+		})
+	}
+}
+
+func TestPingHTTPS(t *testing.T) {
+	srv, pool := newTLSServer(t)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	cfg := &models.Config{
+		Proto:      models.HTTP,
+		Host:       "example.com",
+		Port:       port,
+		TLS:        true,
+		TLSRootCAs: pool,
+		TimeoutDur: 2 * time.Second,
+		HTTP:       models.HTTPCheck{Status: []models.StatusRange{{Min: 200, Max: 200}}},
+	}
+	details := &models.Details{}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  cfg,
+		Address: srv.Listener.Addr().String(),
+		Details: details,
+	}
+
+	if _, err := PingHTTP(opts); err != nil {
+		t.Fatalf("PingHTTP() over TLS error = %v, expected nil", err)
+	}
+	if details.TLS == nil || details.HTTP == nil {
+		t.Errorf("PingHTTP() over TLS details = %+v, expected TLS and HTTP info", details)
+	}
+}
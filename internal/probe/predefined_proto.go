@@ -1,26 +1,40 @@
 package probe
 
-import "github.com/sopov/portping/internal/models"
+import (
+	"encoding/hex"
+	"github.com/sopov/portping/internal/echo"
+	"github.com/sopov/portping/internal/models"
+	"time"
+)
 
 type Preset struct {
 	Proto         models.Proto
 	Port          string
 	UDPPayloadHex string
+	TLS           bool
+	// Expect is the default banner pattern used with -banner.
+	Expect string
+	// Validate checks UDP replies; nil accepts any reply.
+	Validate Validator
+	// Prepare, when set, returns the UDP request of an attempt sent at the
+	// given time, e.g. to stamp it; it must not modify payload.
+	Prepare func(payload []byte, sent time.Time) []byte
 }
 
 var Predefined = map[string]Preset{
 	// Common UDP ports
-	"dns":  {Proto: models.UDP, Port: "53", UDPPayloadHex: "0000010000000000000100000377777706676f6f676c6503636f6d0000010001"},
-	"ntp":  {Proto: models.UDP, Port: "123", UDPPayloadHex: "1b0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"},
-	"stun": {Proto: models.UDP, Port: "3478", UDPPayloadHex: "000100002112a442636363636363636363636363"},
+	"dns":  {Proto: models.UDP, Port: "53", UDPPayloadHex: "0000010000000000000100000377777706676f6f676c6503636f6d0000010001", Validate: ValidateDNS, Prepare: prepareDNS},
+	"ntp":  {Proto: models.UDP, Port: "123", UDPPayloadHex: "1b0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000", Validate: ValidateNTP, Prepare: prepareNTP},
+	"stun": {Proto: models.UDP, Port: "3478", UDPPayloadHex: "000100002112a442636363636363636363636363", Validate: ValidateSTUN, Prepare: prepareSTUN},
+	"echo": {Proto: models.UDP, Port: "7", UDPPayloadHex: hex.EncodeToString((&echo.Packet{}).Marshal()), Validate: ValidateEcho, Prepare: prepareEcho},
 	// Common TCP ports
-	"ftp":      {Proto: models.TCP, Port: "21"},
-	"ssh":      {Proto: models.TCP, Port: "22"},
-	"smtp":     {Proto: models.TCP, Port: "25"},
+	"ftp":      {Proto: models.TCP, Port: "21", Expect: `^220`},
+	"ssh":      {Proto: models.TCP, Port: "22", Expect: `^SSH-2\.0`},
+	"smtp":     {Proto: models.TCP, Port: "25", Expect: `^220`},
 	"http":     {Proto: models.TCP, Port: "80"},
-	"pop3":     {Proto: models.TCP, Port: "110"},
-	"imap":     {Proto: models.TCP, Port: "143"},
-	"https":    {Proto: models.TCP, Port: "443"},
+	"pop3":     {Proto: models.TCP, Port: "110", Expect: `^\+OK`},
+	"imap":     {Proto: models.TCP, Port: "143", Expect: `^\* OK`},
+	"https":    {Proto: models.TCP, Port: "443", TLS: true},
 	"mysql":    {Proto: models.TCP, Port: "3306"},
 	"postgres": {Proto: models.TCP, Port: "5432"},
 }
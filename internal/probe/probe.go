@@ -1,14 +1,22 @@
 package probe
 
 import (
-	"context"
+	"bufio"
 	"errors"
 	"fmt"
 	"github.com/sopov/portping/internal/models"
 	"net"
+	"strings"
 	"time"
 )
 
+const (
+	// maxBanner limits how much of a service banner is read.
+	maxBanner = 1024
+	// maxDatagram is the largest UDP reply that is read.
+	maxDatagram = 65535
+)
+
 func getIPv4(cfg *models.Config, addr net.IP) (string, bool) {
 	if !cfg.AllowIPv4 {
 		return "", false
@@ -48,47 +56,63 @@ func getIP(cfg *models.Config, addr net.IP) (models.IP, bool) {
 	return models.IP{}, false
 }
 
+// GetAddrs returns the addresses of cfg.Host allowed by the config.
 func GetAddrs(cfg *models.Config) ([]models.IP, error) {
-	if ip := net.ParseIP(cfg.Host); ip != nil {
-		if got, ok := getIP(cfg, ip); ok {
-			return []models.IP{got}, nil
-		}
-		return nil, errors.New("no addresses found")
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.TimeoutDur)
-	defer cancel()
+	ips, _, err := Resolve(cfg)
+	return ips, err
+}
 
-	addrs, err := net.DefaultResolver.LookupIP(ctx, "ip", cfg.Host)
+func PingTCP(opts models.PingOptions) (time.Duration, error) {
+	start := time.Now()
+	conn, err := dial(opts.Context, opts, models.TCP.String(), opts.Address)
+	elapsed := time.Since(start)
 	if err != nil {
-		return nil, fmt.Errorf("resolve host `%s`: %w", cfg.Host, err)
+		return elapsed, err
 	}
+	defer conn.Close()
 
-	ips := make([]models.IP, 0, len(addrs))
-	for _, addr := range addrs {
-		if ip, found := getIP(cfg, addr); found {
-			ips = append(ips, ip)
-		}
+	if !opts.Config.Banner {
+		return elapsed, nil
 	}
-	if len(ips) == 0 {
-		return ips, errors.New("no addresses found")
+	if opts.Details != nil {
+		opts.Details.Connect = elapsed
 	}
+	err = readBanner(opts, conn)
 
-	return ips, nil
+	return time.Since(start), err
 }
 
-func PingTCP(opts models.PingOptions) (time.Duration, error) {
-	start := time.Now()
-	d := net.Dialer{}
-	conn, err := d.DialContext(opts.Context, models.TCP.String(), opts.Address)
-	elapsed := time.Since(start)
-	if err != nil {
-		return elapsed, err
+// readBanner reads the first line the server sends after the connection is
+// established and matches it against Config.Expect.
+func readBanner(opts models.PingOptions, conn net.Conn) error {
+	deadline := time.Now().Add(opts.Config.TimeoutDur)
+	if d, ok := opts.Context.Deadline(); ok {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
 	}
-	_ = conn.Close()
 
-	return elapsed, nil
+	line, err := bufio.NewReaderSize(conn, maxBanner).ReadSlice('\n')
+	banner := strings.TrimRight(string(line), "\r\n")
+	if opts.Details != nil {
+		opts.Details.Banner = banner
+	}
 
+	if banner == "" {
+		msg := "no banner received"
+		if err != nil {
+			msg += ": " + err.Error()
+		}
+		return &BannerError{Msg: msg}
+	}
+	if expect := opts.Config.Expect; expect != nil && !expect.MatchString(banner) {
+		return &BannerError{
+			Banner: banner,
+			Msg:    fmt.Sprintf("banner %q does not match `%s`", banner, expect),
+		}
+	}
+	return nil
 }
 
 func PingUDP(opts models.PingOptions) (time.Duration, error) {
@@ -97,8 +121,7 @@ func PingUDP(opts models.PingOptions) (time.Duration, error) {
 	}
 
 	start := time.Now()
-	d := net.Dialer{}
-	conn, err := d.DialContext(opts.Context, models.UDP.String(), opts.Address)
+	conn, err := dial(opts.Context, opts, models.UDP.String(), opts.Address)
 	if err != nil {
 		return time.Since(start), err
 	}
@@ -107,14 +130,38 @@ func PingUDP(opts models.PingOptions) (time.Duration, error) {
 	if err := conn.SetWriteDeadline(time.Now().Add(opts.Config.TimeoutDur)); err != nil {
 		return time.Since(start), err
 	}
-	if _, err = conn.Write(opts.Payload); err != nil {
+	pr, isPreset := GetPreset(opts.Config.Preset)
+	payload := opts.Payload
+	sent := time.Now()
+	if isPreset && pr.Prepare != nil {
+		payload = pr.Prepare(payload, sent)
+	}
+	if _, err = conn.Write(payload); err != nil {
 		return time.Since(start), err
 	}
 	if err := conn.SetReadDeadline(time.Now().Add(opts.Config.TimeoutDur)); err != nil {
 		return time.Since(start), err
 	}
-	var tmp [1]byte
-	_, err = conn.Read(tmp[:])
+	buf := make([]byte, maxDatagram)
+	n, err := conn.Read(buf)
+	received := time.Now()
+	elapsed := received.Sub(start)
+	if err != nil {
+		return elapsed, err
+	}
 
-	return time.Since(start), err
+	info := &models.UDPInfo{Bytes: n}
+	if opts.Details != nil {
+		opts.Details.UDP = info
+	}
+	if isPreset && pr.Validate != nil {
+		err = pr.Validate(Exchange{
+			Request:  payload,
+			Response: buf[:n],
+			Sent:     sent,
+			Received: received,
+		}, info)
+	}
+
+	return elapsed, err
 }
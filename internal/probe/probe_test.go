@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"github.com/sopov/portping/internal/models"
 	"net"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -439,3 +440,78 @@ func TestGetAddrs_NoAddressesAfterFilter(t *testing.T) {
 		t.Errorf("GetAddrs() expected empty slice, got %d IPs", len(ips))
 	}
 }
+
+func startBannerServer(t *testing.T, banner string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if banner != "" {
+					_, _ = conn.Write([]byte(banner))
+				}
+				// keep silent connections open until the client gives up
+				_, _ = conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestPingTCP_Banner(t *testing.T) {
+	tests := []struct {
+		name      string
+		banner    string
+		expect    string
+		wantErr   bool
+		wantClass models.ErrClass
+		wantLine  string
+	}{
+		{"Matching banner", "SSH-2.0-OpenSSH_9.6\r\n", `^SSH-2\.0`, false, models.ErrNone, "SSH-2.0-OpenSSH_9.6"},
+		{"Any banner", "220 ready\r\n", "", false, models.ErrNone, "220 ready"},
+		{"Mismatch", "421 busy\r\n", `^220`, true, models.ErrBanner, "421 busy"},
+		{"Silent server", "", `^220`, true, models.ErrBanner, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startBannerServer(t, tt.banner)
+			cfg := &models.Config{
+				Proto:      models.TCP,
+				TimeoutDur: 200 * time.Millisecond,
+				Banner:     true,
+			}
+			if tt.expect != "" {
+				cfg.Expect = regexp.MustCompile(tt.expect)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.TimeoutDur)
+			defer cancel()
+			details := &models.Details{}
+			opts := models.PingOptions{Context: ctx, Config: cfg, Address: addr, Details: details}
+
+			_, err := PingTCP(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PingTCP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := ClassifyError(err); got != tt.wantClass {
+				t.Errorf("ClassifyError() = %q, expected %q", got, tt.wantClass)
+			}
+			if details.Banner != tt.wantLine {
+				t.Errorf("Details.Banner = %q, expected %q", details.Banner, tt.wantLine)
+			}
+			if details.Connect <= 0 {
+				t.Errorf("Details.Connect = %v, expected > 0", details.Connect)
+			}
+		})
+	}
+}
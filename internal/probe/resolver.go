@@ -0,0 +1,335 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsTypeCNAME is the resource record type of an alias.
+const dnsTypeCNAME = 5
+
+// newResolver returns the resolver of cfg: the system one unless
+// cfg.Resolver names a server, which is asked directly with the responses
+// tapped by rec.
+func newResolver(cfg *models.Config, rec *cnameRecorder) *net.Resolver {
+	if cfg.Resolver == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{PreferGo: true, Dial: rec.dial(cfg)}
+}
+
+// canonicalName returns the canonical name of host from the system
+// resolver when host is an alias. Unlike a tapped lookup it only knows the
+// end of the CNAME chain, and it costs a query of its own, which Resolve
+// counts in Resolution.Duration.
+func canonicalName(ctx context.Context, host string) []string {
+	cname, err := net.DefaultResolver.LookupCNAME(ctx, host)
+	if err != nil {
+		return nil
+	}
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	// the host itself, possibly with a search domain appended
+	if cname == host || strings.HasPrefix(cname, host+".") {
+		return nil
+	}
+	return []string{cname}
+}
+
+// Resolve looks up the addresses of cfg.Host through cfg.Resolver, or the
+// system resolver when it is empty, and describes the lookup. IP literals
+// are not looked up and have no Resolution.
+//
+// Names listed in the hosts file are answered from there without asking
+// any DNS server.
+func Resolve(cfg *models.Config) ([]models.IP, *models.Resolution, error) {
+	if ip := net.ParseIP(cfg.Host); ip != nil {
+		if got, ok := getIP(cfg, ip); ok {
+			return []models.IP{got}, nil, nil
+		}
+		return nil, nil, errors.New("no addresses found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.TimeoutDur)
+	defer cancel()
+
+	rec := &cnameRecorder{}
+	resolver := newResolver(cfg, rec)
+	start := time.Now()
+	addrs, err := resolver.LookupIP(ctx, "ip", cfg.Host)
+	res := &models.Resolution{Server: cfg.Resolver}
+	if err != nil {
+		res.Duration = time.Since(start)
+		return nil, res, fmt.Errorf("resolve host `%s`: %w", cfg.Host, err)
+	}
+	if resolver == net.DefaultResolver {
+		res.CNAMEs = canonicalName(ctx, cfg.Host)
+	} else {
+		res.CNAMEs = rec.chain(cfg.Host)
+	}
+	res.Duration = time.Since(start)
+
+	ips := make([]models.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip, found := getIP(cfg, addr); found {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return ips, res, errors.New("no addresses found")
+	}
+
+	return ips, res, nil
+}
+
+// SRVRecord is a target of an SRV lookup.
+type SRVRecord struct {
+	Host     string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+// LookupSRV returns the records of the SRV name cfg.Host through the same
+// resolver as Resolve, ordered by priority and then by descending weight.
+func LookupSRV(cfg *models.Config) ([]SRVRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.TimeoutDur)
+	defer cancel()
+
+	_, srvs, err := newResolver(cfg, &cnameRecorder{}).LookupSRV(ctx, "", "", cfg.Host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve SRV `%s`: %w", cfg.Host, err)
+	}
+	records := make([]SRVRecord, 0, len(srvs))
+	for _, srv := range srvs {
+		// a single "." target means the service is not available
+		if srv.Target == "." {
+			continue
+		}
+		records = append(records, SRVRecord{
+			Host:     strings.TrimSuffix(srv.Target, "."),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("SRV `%s` has no targets", cfg.Host)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+	return records, nil
+}
+
+// cnameRecorder collects the CNAME records of the DNS responses read by
+// the resolver, which only reports the final canonical name itself.
+type cnameRecorder struct {
+	mu      sync.Mutex
+	aliases map[string]string
+}
+
+// dial connects the resolver to cfg.Resolver from the source address and
+// interface of the probes and taps the responses.
+func (r *cnameRecorder) dial(cfg *models.Config) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		address = cfg.Resolver
+		if cfg.ResolverTCP {
+			network = models.TCP.String()
+		}
+		conn, err := newDNSDialer(cfg, network).DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		// the resolver picks datagram or stream framing by this interface
+		if pc, ok := conn.(net.PacketConn); ok {
+			return &tapPacketConn{tapConn: tapConn{Conn: conn, rec: r}, pc: pc}, nil
+		}
+		return &tapConn{Conn: conn, rec: r, stream: true}, nil
+	}
+}
+
+func (r *cnameRecorder) record(msg []byte) {
+	aliases := dnsCNAMEs(msg)
+	if len(aliases) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.aliases == nil {
+		r.aliases = make(map[string]string, len(aliases))
+	}
+	for owner, target := range aliases {
+		r.aliases[owner] = target
+	}
+}
+
+// chain follows the recorded aliases from host. When host itself is not an
+// alias, e.g. because a search domain was appended, the chain starts at the
+// only owner that is not the target of another alias.
+func (r *cnameRecorder) chain(host string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if _, ok := r.aliases[name]; !ok {
+		targets := make(map[string]bool, len(r.aliases))
+		for _, target := range r.aliases {
+			targets[target] = true
+		}
+		var heads []string
+		for owner := range r.aliases {
+			if !targets[owner] {
+				heads = append(heads, owner)
+			}
+		}
+		if len(heads) != 1 {
+			return nil
+		}
+		name = heads[0]
+	}
+
+	var chain []string
+	seen := map[string]bool{name: true}
+	for {
+		next, ok := r.aliases[name]
+		if !ok || seen[next] {
+			return chain
+		}
+		chain = append(chain, next)
+		seen[next] = true
+		name = next
+	}
+}
+
+// tapConn passes every DNS response read from the resolver connection to
+// the recorder. On streams the messages carry a two byte length prefix and
+// may be split across reads.
+type tapConn struct {
+	net.Conn
+	rec    *cnameRecorder
+	stream bool
+	buf    []byte
+}
+
+func (c *tapConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.stream {
+		c.rec.record(b[:n])
+		return n, err
+	}
+	c.buf = append(c.buf, b[:n]...)
+	for len(c.buf) >= 2 {
+		l := int(binary.BigEndian.Uint16(c.buf))
+		if len(c.buf) < 2+l {
+			break
+		}
+		c.rec.record(c.buf[2 : 2+l])
+		c.buf = c.buf[2+l:]
+	}
+	return n, err
+}
+
+// tapPacketConn is a tapConn over a datagram socket.
+type tapPacketConn struct {
+	tapConn
+	pc net.PacketConn
+}
+
+func (c *tapPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(b)
+	c.rec.record(b[:n])
+	return n, addr, err
+}
+
+func (c *tapPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
+
+// dnsCNAMEs returns the CNAME records in the answer section of a DNS
+// response as owner to target, lowercased and without the trailing dot.
+func dnsCNAMEs(msg []byte) map[string]string {
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return nil
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for range questions {
+		_, next, ok := dnsName(msg, off)
+		if !ok {
+			return nil
+		}
+		off = next + 4 // type, class
+	}
+
+	var aliases map[string]string
+	for range answers {
+		owner, next, ok := dnsName(msg, off)
+		if !ok || next+10 > len(msg) {
+			return aliases
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		rdata := next + 10 // type, class, TTL, length
+		off = rdata + int(binary.BigEndian.Uint16(msg[next+8:]))
+		if off > len(msg) {
+			return aliases
+		}
+		if typ != dnsTypeCNAME {
+			continue
+		}
+		if target, _, ok := dnsName(msg, rdata); ok {
+			if aliases == nil {
+				aliases = make(map[string]string)
+			}
+			aliases[owner] = target
+		}
+	}
+	return aliases
+}
+
+// dnsName decodes the possibly compressed domain name at off. It returns
+// the name and the offset of the data that follows it in msg.
+func dnsName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	end := -1
+	for jumps := 0; off < len(msg); {
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), end, true
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > len(msg)/2 {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		case l&0xC0 != 0:
+			return "", 0, false
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, false
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,188 @@
+package probe
+
+import (
+	"github.com/sopov/portping/internal/dnstest"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const stubAddr = "192.0.2.10"
+
+// stubSRV are the records of _db._tcp.split.test in answer order.
+var stubSRV = []SRVRecord{
+	{Host: "a.split.test", Port: 5432, Priority: 10, Weight: 20},
+	{Host: "b.split.test", Port: 5433, Priority: 10, Weight: 80},
+	{Host: "c.split.test", Port: 5434, Priority: 5, Weight: 0},
+}
+
+// startStubDNS serves www.split.test through two aliases with a single IPv4
+// address and no IPv6 address, and the stubSRV records.
+func startStubDNS(t *testing.T) (udpAddr, tcpAddr string) {
+	zone := dnstest.Zone{
+		CNAME: map[string]string{
+			"www.split.test":  "edge.split.test",
+			"edge.split.test": "node.split.test",
+		},
+		Addrs: map[string][]string{"node.split.test": {stubAddr}},
+		SRV:   map[string][]dnstest.SRV{},
+	}
+	for _, srv := range stubSRV {
+		zone.SRV["_db._tcp.split.test"] = append(zone.SRV["_db._tcp.split.test"], dnstest.SRV{Target: srv.Host, Port: srv.Port, Priority: srv.Priority, Weight: srv.Weight})
+	}
+	return dnstest.Start(t, zone)
+}
+
+func TestResolve_CustomResolver(t *testing.T) {
+	udpAddr, tcpAddr := startStubDNS(t)
+
+	tests := []struct {
+		name     string
+		resolver string
+		tcp      bool
+	}{
+		{"udp", udpAddr, false},
+		{"tcp", tcpAddr, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{
+				Host:        "www.split.test",
+				AllowIPv4:   true,
+				AllowIPv6:   true,
+				TimeoutDur:  2 * time.Second,
+				Resolver:    tt.resolver,
+				ResolverTCP: tt.tcp,
+			}
+			ips, res, err := Resolve(cfg)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if len(ips) != 1 || ips[0].IP != stubAddr || !ips[0].IsIPv4 {
+				t.Errorf("Resolve() IPs = %+v, expected [%s]", ips, stubAddr)
+			}
+			if res == nil {
+				t.Fatal("Resolve() returned no resolution")
+			}
+			if res.Server != tt.resolver {
+				t.Errorf("Server = %q, expected %q", res.Server, tt.resolver)
+			}
+			if res.Duration <= 0 {
+				t.Errorf("Duration = %v, expected > 0", res.Duration)
+			}
+			want := []string{"edge.split.test", "node.split.test"}
+			if !reflect.DeepEqual(res.CNAMEs, want) {
+				t.Errorf("CNAMEs = %v, expected %v", res.CNAMEs, want)
+			}
+		})
+	}
+}
+
+func TestResolve_Source(t *testing.T) {
+	udpAddr, tcpAddr := startStubDNS(t)
+
+	for _, tt := range []struct {
+		name     string
+		resolver string
+		tcp      bool
+	}{
+		{"udp", udpAddr, false},
+		{"tcp", tcpAddr, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// a TCP probe with a fixed port, which the queries must not take
+			cfg := &models.Config{
+				Proto:       models.TCP,
+				Host:        "www.split.test",
+				AllowIPv4:   true,
+				TimeoutDur:  2 * time.Second,
+				Resolver:    tt.resolver,
+				ResolverTCP: tt.tcp,
+				Source:      "127.0.0.1",
+				SourcePort:  freePort(t, "tcp"),
+			}
+			if ips, _, err := Resolve(cfg); err != nil || len(ips) != 1 {
+				t.Fatalf("Resolve() = %v, %v; expected %s", ips, err, stubAddr)
+			}
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	if r := newResolver(&models.Config{}, &cnameRecorder{}); r != net.DefaultResolver {
+		t.Errorf("newResolver() without -resolver = %+v, expected the system resolver", r)
+	}
+	r := newResolver(&models.Config{Resolver: "192.0.2.1:53"}, &cnameRecorder{})
+	if r == net.DefaultResolver || r.Dial == nil || !r.PreferGo {
+		t.Errorf("newResolver() with -resolver = %+v, expected a tapped Go resolver", r)
+	}
+}
+
+func TestResolve_SystemResolver(t *testing.T) {
+	cfg := &models.Config{Host: "localhost", AllowIPv4: true, AllowIPv6: true, TimeoutDur: 2 * time.Second}
+	ips, res, err := Resolve(cfg)
+	if err != nil || len(ips) == 0 {
+		t.Fatalf("Resolve() = %v, %v", ips, err)
+	}
+	if res == nil || res.Server != "" || len(res.CNAMEs) != 0 {
+		t.Errorf("Resolve() resolution = %+v, expected the system resolver and no CNAMEs", res)
+	}
+}
+
+func TestResolve_IPLiteral(t *testing.T) {
+	cfg := &models.Config{Host: "127.0.0.1", AllowIPv4: true, Resolver: "192.0.2.1:53"}
+	ips, res, err := Resolve(cfg)
+	if err != nil || len(ips) != 1 {
+		t.Fatalf("Resolve() = %v, %v", ips, err)
+	}
+	if res != nil {
+		t.Errorf("Resolve() resolution = %+v, expected nil for an IP literal", res)
+	}
+}
+
+func TestDNSCNAMEs_Compressed(t *testing.T) {
+	msg := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		// question: a.example IN A
+		1, 'a', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, 0, 1, 0, 1,
+		// answer: a.example CNAME B.example, both compressed
+		0xC0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 4, 1, 'B', 0xC0, 14,
+	}
+	got := dnsCNAMEs(msg)
+	want := map[string]string{"a.example": "b.example"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dnsCNAMEs() = %v, expected %v", got, want)
+	}
+
+	if got := dnsCNAMEs(msg[:len(msg)-3]); len(got) != 0 {
+		t.Errorf("dnsCNAMEs(truncated) = %v, expected none", got)
+	}
+	loop := append(msg[:12:12], 0xC0, 12)
+	if _, _, ok := dnsName(loop, 12); ok {
+		t.Error("dnsName() accepted a compression loop")
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	udpAddr, _ := startStubDNS(t)
+	cfg := &models.Config{
+		Host:       "_db._tcp.split.test",
+		TimeoutDur: 2 * time.Second,
+		Resolver:   udpAddr,
+	}
+	records, err := LookupSRV(cfg)
+	if err != nil {
+		t.Fatalf("LookupSRV() error = %v", err)
+	}
+	want := []SRVRecord{stubSRV[2], stubSRV[1], stubSRV[0]}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("LookupSRV() = %+v, expected %+v", records, want)
+	}
+
+	cfg.Host = "_none._tcp.split.test"
+	if _, err := LookupSRV(cfg); err == nil {
+		t.Error("LookupSRV() expected error for a name without records")
+	}
+}
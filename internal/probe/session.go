@@ -0,0 +1,218 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"github.com/sopov/portping/internal/echo"
+	"github.com/sopov/portping/internal/models"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// seqWindow is how many sequence numbers behind the newest reply are
+// remembered to detect duplicates.
+const seqWindow = 4096
+
+// EchoSession is a TWAMP-light style two-way measurement against a
+// stamping echo reflector ("portping listen -timestamp"). All attempts use
+// one UDP socket and one session ID, so replies that arrive late, twice or
+// out of order are still seen, and the reflector sequence numbers tell
+// forward from reverse loss.
+type EchoSession struct {
+	conn net.Conn
+	id   uint32
+	done chan struct{}
+
+	mu       sync.Mutex
+	seq      uint32
+	waiting  map[uint32]chan echoReply
+	received map[uint32]bool
+	counters models.SeqStats
+	// maxSeq is the highest client sequence number received, and
+	// maxRefl the highest reflector one, carried by the reply to seqAtRefl.
+	maxSeq, maxRefl, seqAtRefl uint32
+	lastFwd, lastRev           time.Duration
+	hasLast                    bool
+}
+
+type echoReply struct {
+	packet   *echo.Packet
+	bytes    int
+	received time.Time
+	err      error
+}
+
+// NewEchoSession opens a session to the reflector at address with dialer
+// d, a plain dialer when nil.
+func NewEchoSession(ctx context.Context, d *net.Dialer, address string) (*EchoSession, error) {
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, models.UDP.String(), address)
+	if err != nil {
+		return nil, err
+	}
+	s := &EchoSession{
+		conn:     conn,
+		id:       rand.Uint32() | 1, // never 0, which is not numbered
+		done:     make(chan struct{}),
+		waiting:  make(map[uint32]chan echoReply),
+		received: make(map[uint32]bool),
+	}
+	go s.receive()
+	return s, nil
+}
+
+// Close ends the session; replies still in flight are not counted.
+func (s *EchoSession) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+// Ping sends the next packet of the session, built from the echo packet
+// in opts.Payload, and waits for its reply until the context is done.
+func (s *EchoSession) Ping(opts models.PingOptions) (time.Duration, error) {
+	p, ok := echo.Parse(opts.Payload)
+	if !ok {
+		return 0, errors.New("two-way session requires an echo packet payload")
+	}
+	reply := make(chan echoReply, 1)
+	s.mu.Lock()
+	s.seq++
+	seq := s.seq
+	s.waiting[seq] = reply
+	s.counters.Sent++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiting, seq)
+		s.mu.Unlock()
+	}()
+
+	start := time.Now()
+	p.Seq, p.Session, p.Sent = seq, s.id, start
+	b := append(p.Marshal(), opts.Payload[echo.PacketSize:]...)
+	if err := s.conn.SetWriteDeadline(start.Add(opts.Config.TimeoutDur)); err != nil {
+		return time.Since(start), err
+	}
+	if _, err := s.conn.Write(b); err != nil {
+		return time.Since(start), err
+	}
+
+	select {
+	case r := <-reply:
+		elapsed := r.received.Sub(start)
+		if r.err != nil {
+			return elapsed, r.err
+		}
+		info := &models.UDPInfo{Bytes: r.bytes}
+		if opts.Details != nil {
+			opts.Details.UDP = info
+		}
+		if r.packet.Stamped {
+			info.Stamped = true
+			info.Forward = r.packet.Received.Sub(r.packet.Sent)
+			info.Reverse = r.received.Sub(r.packet.Transmitted)
+		}
+		return elapsed, nil
+	case <-opts.Context.Done():
+		return time.Since(start), opts.Context.Err()
+	}
+}
+
+// receive reads the replies of the session until the socket is closed.
+func (s *EchoSession) receive() {
+	defer close(s.done)
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := s.conn.Read(buf)
+		received := time.Now()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// e.g. an ICMP port unreachable fails the pending attempts
+			s.mu.Lock()
+			for seq, ch := range s.waiting {
+				ch <- echoReply{received: received, err: err}
+				delete(s.waiting, seq)
+			}
+			s.mu.Unlock()
+			continue
+		}
+		p, ok := echo.Parse(buf[:n])
+		if !ok || p.Session != s.id {
+			continue
+		}
+		s.mu.Lock()
+		s.account(p, received)
+		if ch := s.waiting[p.Seq]; ch != nil {
+			ch <- echoReply{packet: p, bytes: n, received: received}
+			delete(s.waiting, p.Seq)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// account updates the counters with a reply. s.mu must be held.
+func (s *EchoSession) account(p *echo.Packet, received time.Time) {
+	c := &s.counters
+	if s.received[p.Seq] || p.Seq+seqWindow <= s.maxSeq {
+		c.Duplicates++
+		return
+	}
+	s.received[p.Seq] = true
+	c.Received++
+	if _, waiting := s.waiting[p.Seq]; !waiting && p.Seq <= s.seq {
+		c.Late++
+	}
+	if p.Seq < s.maxSeq {
+		c.Reordered++
+	} else {
+		s.maxSeq = p.Seq
+		for seq := range s.received {
+			if seq+seqWindow <= s.maxSeq {
+				delete(s.received, seq)
+			}
+		}
+	}
+	if p.ReflectorSeq > s.maxRefl {
+		s.maxRefl, s.seqAtRefl = p.ReflectorSeq, p.Seq
+	}
+	if !p.Stamped {
+		return
+	}
+	fwd, rev := p.Received.Sub(p.Sent), received.Sub(p.Transmitted)
+	if s.hasLast {
+		c.ForwardJitter += (absDur(fwd-s.lastFwd) - c.ForwardJitter) / 16
+		c.ReverseJitter += (absDur(rev-s.lastRev) - c.ReverseJitter) / 16
+	}
+	s.lastFwd, s.lastRev, s.hasLast = fwd, rev, true
+}
+
+// Stats returns the counters of the session so far.
+func (s *EchoSession) Stats() models.SeqStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counters
+	lost := c.Sent - c.Received
+	if s.maxRefl > 0 {
+		c.Reflected = int(s.maxRefl)
+		// of the packets up to seqAtRefl the reflector got maxRefl, and
+		// it sent maxRefl replies of which we got the rest
+		c.ForwardLoss = max(int(s.seqAtRefl)-int(s.maxRefl), 0)
+		c.ReverseLoss = max(int(s.maxRefl)-c.Received, 0)
+	}
+	c.Unknown = max(lost-c.ForwardLoss-c.ReverseLoss, 0)
+	return c
+}
+
+func absDur(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,129 @@
+package probe
+
+import (
+	"context"
+	"github.com/sopov/portping/internal/echo"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"testing"
+	"time"
+)
+
+// lossyReflector reflects echo packets like "portping listen -timestamp"
+// but loses, duplicates and reorders some of them by client sequence.
+func lossyReflector(conn net.PacketConn) {
+	var refl uint32
+	var held []byte
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		p, ok := echo.Parse(buf[:n])
+		if !ok {
+			continue
+		}
+		if p.Seq == 2 {
+			continue // lost on the way to the reflector
+		}
+		refl++
+		p.ReflectorSeq = refl
+		p.Stamped = true
+		p.Received, p.Transmitted = time.Now(), time.Now()
+		b := p.Marshal()
+		switch p.Seq {
+		case 3: // lost on the way back
+		case 4:
+			_, _ = conn.WriteTo(b, addr)
+			_, _ = conn.WriteTo(b, addr)
+		case 5:
+			held = b
+		case 6:
+			_, _ = conn.WriteTo(b, addr)
+			_, _ = conn.WriteTo(held, addr)
+		default:
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}
+}
+
+func TestEchoSession(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go lossyReflector(conn)
+
+	s, err := NewEchoSession(context.Background(), nil, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &models.Config{Proto: models.UDP, Preset: "echo", TimeoutDur: 100 * time.Millisecond}
+	var failed []int
+	for i := 1; i <= 7; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.TimeoutDur)
+		details := &models.Details{}
+		_, err := s.Ping(models.PingOptions{Context: ctx, Config: cfg, Payload: presetPayload(t, "echo"), Details: details})
+		cancel()
+		if err != nil {
+			failed = append(failed, i)
+			continue
+		}
+		if details.UDP == nil || !details.UDP.Stamped {
+			t.Errorf("attempt %d details = %+v, expected a stamped reply", i, details.UDP)
+		}
+	}
+	// the duplicate and the late reply may still be in flight
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+
+	if len(failed) != 3 || failed[0] != 2 || failed[1] != 3 || failed[2] != 5 {
+		t.Errorf("failed attempts = %v, expected [2 3 5]", failed)
+	}
+	got := s.Stats()
+	want := models.SeqStats{
+		Sent:        7,
+		Received:    5,
+		Reflected:   6,
+		ForwardLoss: 1,
+		ReverseLoss: 1,
+		Reordered:   1,
+		Duplicates:  1,
+		Late:        1,
+	}
+	got.ForwardJitter, got.ReverseJitter = 0, 0
+	if got != want {
+		t.Errorf("Stats() = %+v\nexpected %+v", got, want)
+	}
+}
+
+func TestEchoSession_Unstamped(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = echo.ServeUDP(ctx, conn, false) }()
+
+	s, err := NewEchoSession(context.Background(), nil, conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &models.Config{Proto: models.UDP, Preset: "echo", TimeoutDur: time.Second}
+	for range 3 {
+		if _, err := s.Ping(models.PingOptions{Context: context.Background(), Config: cfg, Payload: presetPayload(t, "echo")}); err != nil {
+			t.Fatalf("Ping() = %v", err)
+		}
+	}
+	_ = s.Close()
+	// a plain echo server does not number the packets: loss has no direction
+	got := s.Stats()
+	if got.Sent != 3 || got.Received != 3 || got.Reflected != 0 || got.ForwardLoss != 0 || got.ReverseLoss != 0 {
+		t.Errorf("Stats() = %+v", got)
+	}
+}
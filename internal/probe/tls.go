@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/sopov/portping/internal/models"
+	"time"
+)
+
+func tlsConfig(cfg *models.Config) *tls.Config {
+	return &tls.Config{
+		ServerName:         cfg.Host,
+		InsecureSkipVerify: cfg.TLSInsecure, // #nosec G402 -- explicitly requested with -insecure
+		RootCAs:            cfg.TLSRootCAs,
+		NextProtos:         []string{"h2", "http/1.1"},
+	}
+}
+
+// PingTLS connects over TCP and performs a full TLS handshake. The returned
+// duration covers both (and the banner read with -banner); the split is
+// reported through opts.Details.
+func PingTLS(opts models.PingOptions) (time.Duration, error) {
+	start := time.Now()
+	conn, err := dial(opts.Context, opts, models.TCP.String(), opts.Address)
+	connected := time.Since(start)
+	if err != nil {
+		return connected, err
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, tlsConfig(opts.Config))
+	err = tlsConn.HandshakeContext(opts.Context)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, fmt.Errorf("tls handshake: %w", err)
+	}
+
+	if opts.Details != nil {
+		opts.Details.Connect = connected
+		opts.Details.TLS = tlsInfo(tlsConn.ConnectionState(), elapsed-connected)
+		opts.Details.Warning = certWarning(opts.Config, opts.Details.TLS, time.Now())
+	}
+
+	if opts.Config.Banner {
+		err = readBanner(opts, tlsConn)
+		elapsed = time.Since(start)
+	}
+
+	return elapsed, err
+}
+
+func tlsInfo(state tls.ConnectionState, handshake time.Duration) *models.TLSInfo {
+	info := &models.TLSInfo{
+		Handshake:   handshake,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		info.Subject = leaf.Subject.String()
+		info.NotAfter = leaf.NotAfter
+	}
+	return info
+}
+
+func certWarning(cfg *models.Config, info *models.TLSInfo, now time.Time) string {
+	if cfg.CertWarnDays <= 0 || info == nil || info.NotAfter.IsZero() {
+		return ""
+	}
+	left := info.NotAfter.Sub(now)
+	if left > time.Duration(cfg.CertWarnDays)*24*time.Hour {
+		return ""
+	}
+	if left <= 0 {
+		return "certificate expired " + info.NotAfter.UTC().Format(time.DateOnly)
+	}
+	return fmt.Sprintf("certificate expires in %d days (%s)",
+		int(left.Hours()/24), info.NotAfter.UTC().Format(time.DateOnly))
+}
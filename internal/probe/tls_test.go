@@ -0,0 +1,120 @@
+package probe
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/sopov/portping/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTLSServer(t *testing.T) (*httptest.Server, *x509.CertPool) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return srv, pool
+}
+
+func TestPingTLS(t *testing.T) {
+	srv, pool := newTLSServer(t)
+
+	cfg := &models.Config{
+		Proto:      models.TCP,
+		Host:       "example.com", // httptest certificate is valid for example.com
+		TLS:        true,
+		TLSRootCAs: pool,
+		TimeoutDur: 2 * time.Second,
+	}
+	details := &models.Details{}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  cfg,
+		Address: srv.Listener.Addr().String(),
+		Details: details,
+	}
+
+	duration, err := PingTLS(opts)
+	if err != nil {
+		t.Fatalf("PingTLS() error = %v, expected nil", err)
+	}
+	if details.TLS == nil {
+		t.Fatal("PingTLS() did not fill TLS details")
+	}
+	if details.Connect <= 0 || details.TLS.Handshake <= 0 {
+		t.Errorf("PingTLS() connect = %v, handshake = %v, expected both > 0", details.Connect, details.TLS.Handshake)
+	}
+	if details.Connect+details.TLS.Handshake != duration {
+		t.Errorf("PingTLS() connect+handshake = %v, expected total %v", details.Connect+details.TLS.Handshake, duration)
+	}
+	if !strings.HasPrefix(details.TLS.Version, "TLS") || details.TLS.CipherSuite == "" {
+		t.Errorf("PingTLS() version = %q, cipher = %q", details.TLS.Version, details.TLS.CipherSuite)
+	}
+	if details.TLS.NotAfter.IsZero() || details.TLS.Subject == "" {
+		t.Errorf("PingTLS() missing certificate info: %+v", details.TLS)
+	}
+	if details.Warning != "" {
+		t.Errorf("PingTLS() unexpected warning %q", details.Warning)
+	}
+}
+
+func TestPingTLS_Verification(t *testing.T) {
+	srv, _ := newTLSServer(t)
+
+	cfg := &models.Config{
+		Proto:      models.TCP,
+		Host:       "example.com",
+		TLS:        true,
+		TimeoutDur: 2 * time.Second,
+	}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  cfg,
+		Address: srv.Listener.Addr().String(),
+	}
+
+	if _, err := PingTLS(opts); err == nil {
+		t.Error("PingTLS() expected error for untrusted certificate, got nil")
+	}
+
+	cfg.TLSInsecure = true
+	if _, err := PingTLS(opts); err != nil {
+		t.Errorf("PingTLS() with -insecure error = %v, expected nil", err)
+	}
+}
+
+func TestCertWarning(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := &models.TLSInfo{NotAfter: now.Add(10 * 24 * time.Hour)}
+
+	tests := []struct {
+		name     string
+		days     int
+		info     *models.TLSInfo
+		contains string
+	}{
+		{"Disabled", 0, info, ""},
+		{"Far from expiry", 5, info, ""},
+		{"Within window", 30, info, "expires in 10 days"},
+		{"Expired", 30, &models.TLSInfo{NotAfter: now.Add(-time.Hour)}, "expired"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := certWarning(&models.Config{CertWarnDays: tt.days}, tt.info, now)
+			if tt.contains == "" && got != "" {
+				t.Errorf("certWarning() = %q, expected empty", got)
+			}
+			if !strings.Contains(got, tt.contains) {
+				t.Errorf("certWarning() = %q, expected to contain %q", got, tt.contains)
+			}
+		})
+	}
+}
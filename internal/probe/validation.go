@@ -0,0 +1,28 @@
+package probe
+
+import "fmt"
+
+// ValidationError reports a reply that arrived but did not match what the
+// probe expected.
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Msg
+}
+
+func validationErrorf(format string, args ...any) error {
+	return &ValidationError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// BannerError reports a TCP service that accepted the connection but sent
+// no banner, or one that did not match the expected pattern.
+type BannerError struct {
+	Banner string
+	Msg    string
+}
+
+func (e *BannerError) Error() string {
+	return e.Msg
+}
@@ -0,0 +1,278 @@
+package probe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/sopov/portping/internal/echo"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Exchange is a single UDP request/response round trip.
+type Exchange struct {
+	Request  []byte
+	Response []byte
+	Sent     time.Time
+	Received time.Time
+}
+
+// Validator checks a UDP reply against the request that produced it and
+// records what it decoded in info. A non-nil error fails the attempt.
+type Validator func(x Exchange, info *models.UDPInfo) error
+
+var dnsRCodes = map[int]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+func dnsRCodeName(rcode int) string {
+	if name, ok := dnsRCodes[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
+
+// prepareDNS gives a copy of a DNS query a random transaction ID that
+// differs from the one of payload, so a reply to an earlier attempt on the
+// same source port is not taken for the reply to this one.
+func prepareDNS(payload []byte, _ time.Time) []byte {
+	if len(payload) < 12 {
+		return payload
+	}
+	b := append([]byte{}, payload...)
+	for bytes.Equal(b[:2], payload[:2]) {
+		_, _ = rand.Read(b[:2])
+	}
+	return b
+}
+
+// ValidateDNS checks the transaction ID, the QR bit and the RCODE.
+func ValidateDNS(x Exchange, info *models.UDPInfo) error {
+	req, resp := x.Request, x.Response
+	if len(req) < 12 || len(resp) < 12 {
+		return validationErrorf("dns: short message (%d bytes)", len(resp))
+	}
+	if id, want := binary.BigEndian.Uint16(resp), binary.BigEndian.Uint16(req); id != want {
+		return validationErrorf("dns: transaction id %#04x, expected %#04x", id, want)
+	}
+	flags := binary.BigEndian.Uint16(resp[2:])
+	if flags&0x8000 == 0 {
+		return validationErrorf("dns: reply is not a response")
+	}
+	rcode := int(flags & 0x000f)
+	info.RCode = dnsRCodeName(rcode)
+	info.Answers = int(binary.BigEndian.Uint16(resp[6:]))
+	if rcode != 0 {
+		return validationErrorf("dns: %s", info.RCode)
+	}
+	return nil
+}
+
+// ntpEpochOffset is the number of seconds between 1900 and 1970.
+const ntpEpochOffset = 2208988800
+
+func ntpTime(b []byte) time.Time {
+	secs := binary.BigEndian.Uint32(b)
+	frac := binary.BigEndian.Uint32(b[4:])
+	nsec := (int64(frac) * 1e9) >> 32
+	return time.Unix(int64(secs)-ntpEpochOffset, nsec)
+}
+
+func putNTPTime(b []byte, ts time.Time) {
+	secs := uint32(ts.Unix() + ntpEpochOffset)
+	frac := uint32((int64(ts.Nanosecond()) << 32) / 1e9)
+	binary.BigEndian.PutUint32(b, secs)
+	binary.BigEndian.PutUint32(b[4:], frac)
+}
+
+// prepareNTP sets the transmit timestamp of a copy of an NTP request to the
+// send time, which the server returns as the origin timestamp.
+func prepareNTP(payload []byte, sent time.Time) []byte {
+	if len(payload) < 48 {
+		return payload
+	}
+	b := append([]byte{}, payload...)
+	putNTPTime(b[40:48], sent)
+	return b
+}
+
+// ValidateNTP checks that the reply is a synchronized server packet that
+// answers our request and computes the server clock offset.
+func ValidateNTP(x Exchange, info *models.UDPInfo) error {
+	req, resp := x.Request, x.Response
+	if len(req) < 48 || len(resp) < 48 {
+		return validationErrorf("ntp: short packet (%d bytes)", len(resp))
+	}
+	if mode := resp[0] & 0x07; mode != 4 {
+		return validationErrorf("ntp: mode %d, expected 4 (server)", mode)
+	}
+	info.Stratum = int(resp[1])
+	if info.Stratum == 0 {
+		return validationErrorf("ntp: kiss-o'-death %q", string(resp[12:16]))
+	}
+	if info.Stratum > 15 {
+		return validationErrorf("ntp: invalid stratum %d", info.Stratum)
+	}
+	if resp[0]>>6 == 3 {
+		return validationErrorf("ntp: server clock not synchronized")
+	}
+	if string(resp[24:32]) != string(req[40:48]) {
+		return validationErrorf("ntp: origin timestamp does not match request")
+	}
+
+	// RFC 5905: offset = ((T2 - T1) + (T3 - T4)) / 2
+	t2, t3 := ntpTime(resp[32:40]), ntpTime(resp[40:48])
+	info.Offset = (t2.Sub(x.Sent) + t3.Sub(x.Received)) / 2
+	return nil
+}
+
+const (
+	stunMagicCookie      = 0x2112a442
+	stunBindingSuccess   = 0x0101
+	stunBindingError     = 0x0111
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+)
+
+// prepareSTUN gives a copy of a STUN request a random transaction ID.
+func prepareSTUN(payload []byte, _ time.Time) []byte {
+	if len(payload) < 20 {
+		return payload
+	}
+	b := append([]byte{}, payload...)
+	_, _ = rand.Read(b[8:20])
+	return b
+}
+
+// ValidateSTUN checks the binding response type, magic cookie and
+// transaction ID and extracts the (XOR-)MAPPED-ADDRESS.
+func ValidateSTUN(x Exchange, info *models.UDPInfo) error {
+	req, resp := x.Request, x.Response
+	if len(req) < 20 || len(resp) < 20 {
+		return validationErrorf("stun: short message (%d bytes)", len(resp))
+	}
+	if cookie := binary.BigEndian.Uint32(resp[4:]); cookie != stunMagicCookie {
+		return validationErrorf("stun: bad magic cookie %#08x", cookie)
+	}
+	if string(resp[8:20]) != string(req[8:20]) {
+		return validationErrorf("stun: transaction id does not match request")
+	}
+	switch typ := binary.BigEndian.Uint16(resp); typ {
+	case stunBindingSuccess:
+	case stunBindingError:
+		return validationErrorf("stun: binding error response")
+	default:
+		return validationErrorf("stun: unexpected message type %#04x", typ)
+	}
+
+	length := int(binary.BigEndian.Uint16(resp[2:]))
+	attrs := resp[20:]
+	if length < len(attrs) {
+		attrs = attrs[:length]
+	}
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs)
+		alen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+alen > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+alen]
+		switch typ {
+		case stunXORMappedAddress:
+			info.Mapped = stunAddress(value, resp[4:20], true)
+		case stunMappedAddress:
+			if info.Mapped == "" {
+				info.Mapped = stunAddress(value, nil, false)
+			}
+		}
+		// attributes are padded to 4 bytes
+		next := 4 + (alen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	return nil
+}
+
+// stunAddress decodes a (XOR-)MAPPED-ADDRESS value. key is the magic
+// cookie followed by the transaction ID.
+func stunAddress(v, key []byte, xor bool) string {
+	if len(v) < 4 {
+		return ""
+	}
+	port := binary.BigEndian.Uint16(v[2:])
+	var ip net.IP
+	switch v[1] {
+	case 0x01:
+		if len(v) < 8 {
+			return ""
+		}
+		ip = append(net.IP{}, v[4:8]...)
+	case 0x02:
+		if len(v) < 20 {
+			return ""
+		}
+		ip = append(net.IP{}, v[4:20]...)
+	default:
+		return ""
+	}
+	if xor {
+		port ^= stunMagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// echoSeq numbers the echo packets sent by this process.
+var echoSeq atomic.Uint32
+
+// prepareEcho stamps a copy of an echo packet payload with a new sequence
+// number and the send time; other payloads are sent as is.
+func prepareEcho(payload []byte, sent time.Time) []byte {
+	if !echo.IsPacket(payload) {
+		return payload
+	}
+	p, _ := echo.Parse(payload)
+	p.Seq = echoSeq.Add(1)
+	p.Sent = sent
+	b := p.Marshal()
+	return append(b, payload[echo.PacketSize:]...)
+}
+
+// ValidateEcho checks that the reply is the request sent back (RFC 862).
+// A reply stamped by "portping listen -timestamp" also gives the one-way
+// delays, which assume synchronized clocks.
+func ValidateEcho(x Exchange, info *models.UDPInfo) error {
+	req, resp := x.Request, x.Response
+	p, ok := echo.Parse(resp)
+	if !ok || !echo.IsPacket(req) {
+		if !bytes.Equal(req, resp) {
+			return validationErrorf("echo: reply differs from request (%d of %d bytes)", len(resp), len(req))
+		}
+		return nil
+	}
+	sent, _ := echo.Parse(req)
+	if len(resp) != len(req) || p.Seq != sent.Seq || !p.Sent.Equal(sent.Sent) {
+		return validationErrorf("echo: reply does not match request")
+	}
+	if !bytes.Equal(resp[echo.PacketSize:], req[echo.PacketSize:]) {
+		return validationErrorf("echo: padding differs from request")
+	}
+	if p.Stamped {
+		info.Stamped = true
+		info.Forward = p.Received.Sub(p.Sent)
+		info.Reverse = x.Received.Sub(p.Transmitted)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/sopov/portping/internal/echo"
+	"github.com/sopov/portping/internal/models"
+	"net"
+	"testing"
+	"time"
+)
+
+func presetPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(Predefined[name].UDPPayloadHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func dnsReply(req []byte, flags uint16, answers uint16) []byte {
+	resp := append([]byte{}, req...)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[6:], answers)
+	return resp
+}
+
+func TestValidateDNS(t *testing.T) {
+	req := presetPayload(t, "dns")
+	badID := dnsReply(req, 0x8180, 1)
+	badID[1] = 0x42
+
+	tests := []struct {
+		name      string
+		resp      []byte
+		wantErr   bool
+		wantRCode string
+	}{
+		{"NOERROR", dnsReply(req, 0x8180, 1), false, "NOERROR"},
+		{"SERVFAIL", dnsReply(req, 0x8182, 0), true, "SERVFAIL"},
+		{"Mismatched ID", badID, true, ""},
+		{"Not a response", dnsReply(req, 0x0100, 0), true, ""},
+		{"Short", []byte{0, 0}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &models.UDPInfo{}
+			err := ValidateDNS(Exchange{Request: req, Response: tt.resp}, info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateDNS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if info.RCode != tt.wantRCode {
+				t.Errorf("ValidateDNS() rcode = %q, expected %q", info.RCode, tt.wantRCode)
+			}
+			if err != nil && ClassifyError(err) != models.ErrValidation {
+				t.Errorf("ClassifyError() = %q, expected %q", ClassifyError(err), models.ErrValidation)
+			}
+		})
+	}
+}
+
+func TestValidateNTP(t *testing.T) {
+	req := presetPayload(t, "ntp")
+	sent := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	received := sent.Add(20 * time.Millisecond)
+
+	reply := func(first, stratum byte) []byte {
+		resp := make([]byte, 48)
+		resp[0], resp[1] = first, stratum
+		copy(resp[24:32], req[40:48])
+		// server clock is 100ms ahead, 10ms each way
+		putNTPTime(resp[32:40], sent.Add(110*time.Millisecond))
+		putNTPTime(resp[40:48], sent.Add(111*time.Millisecond))
+		return resp
+	}
+
+	info := &models.UDPInfo{}
+	err := ValidateNTP(Exchange{Request: req, Response: reply(0x24, 2), Sent: sent, Received: received}, info)
+	if err != nil {
+		t.Fatalf("ValidateNTP() error = %v, expected nil", err)
+	}
+	if info.Stratum != 2 {
+		t.Errorf("ValidateNTP() stratum = %d, expected 2", info.Stratum)
+	}
+	if off := info.Offset; off < 100*time.Millisecond || off > 101*time.Millisecond {
+		t.Errorf("ValidateNTP() offset = %v, expected ~100.5ms", off)
+	}
+
+	bad := map[string][]byte{
+		"Client mode":    reply(0x23, 2),
+		"Kiss-o'-death":  reply(0x24, 0),
+		"Unsynchronized": reply(0xe4, 2),
+		"Short":          make([]byte, 10),
+	}
+	for name, resp := range bad {
+		if err := ValidateNTP(Exchange{Request: req, Response: resp, Sent: sent, Received: received}, &models.UDPInfo{}); err == nil {
+			t.Errorf("ValidateNTP(%s) expected error, got nil", name)
+		}
+	}
+}
+
+func TestValidateSTUN(t *testing.T) {
+	req := presetPayload(t, "stun")
+
+	reply := func(typ uint16, txid []byte) []byte {
+		resp := make([]byte, 20, 32)
+		binary.BigEndian.PutUint16(resp, typ)
+		binary.BigEndian.PutUint16(resp[2:], 12)
+		binary.BigEndian.PutUint32(resp[4:], stunMagicCookie)
+		copy(resp[8:20], txid)
+		// XOR-MAPPED-ADDRESS 192.0.2.1:32853
+		attr := make([]byte, 12)
+		binary.BigEndian.PutUint16(attr, stunXORMappedAddress)
+		binary.BigEndian.PutUint16(attr[2:], 8)
+		attr[5] = 0x01
+		binary.BigEndian.PutUint16(attr[6:], 32853^(stunMagicCookie>>16))
+		binary.BigEndian.PutUint32(attr[8:], binary.BigEndian.Uint32(net.ParseIP("192.0.2.1").To4())^stunMagicCookie)
+		return append(resp, attr...)
+	}
+
+	info := &models.UDPInfo{}
+	if err := ValidateSTUN(Exchange{Request: req, Response: reply(stunBindingSuccess, req[8:20])}, info); err != nil {
+		t.Fatalf("ValidateSTUN() error = %v, expected nil", err)
+	}
+	if info.Mapped != "192.0.2.1:32853" {
+		t.Errorf("ValidateSTUN() mapped = %q, expected 192.0.2.1:32853", info.Mapped)
+	}
+
+	if err := ValidateSTUN(Exchange{Request: req, Response: reply(stunBindingSuccess, make([]byte, 12))}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateSTUN() expected error for mismatched transaction id")
+	}
+	if err := ValidateSTUN(Exchange{Request: req, Response: reply(stunBindingError, req[8:20])}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateSTUN() expected error for binding error response")
+	}
+}
+
+func TestPrepare_RejectsTemplateReply(t *testing.T) {
+	sent := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dnsTemplate := presetPayload(t, "dns")
+	dnsReq := prepareDNS(dnsTemplate, sent)
+	if err := ValidateDNS(Exchange{Request: dnsReq, Response: dnsReply(dnsTemplate, 0x8180, 1)}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateDNS() accepted a reply with the template transaction id")
+	}
+	if err := ValidateDNS(Exchange{Request: dnsReq, Response: dnsReply(dnsReq, 0x8180, 1)}, &models.UDPInfo{}); err != nil {
+		t.Errorf("ValidateDNS() error = %v for the reply to the prepared query", err)
+	}
+
+	stunTemplate := presetPayload(t, "stun")
+	stunReq := prepareSTUN(stunTemplate, sent)
+	stunResp := make([]byte, 20)
+	binary.BigEndian.PutUint16(stunResp, stunBindingSuccess)
+	binary.BigEndian.PutUint32(stunResp[4:], stunMagicCookie)
+	copy(stunResp[8:20], stunTemplate[8:20])
+	if err := ValidateSTUN(Exchange{Request: stunReq, Response: stunResp}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateSTUN() accepted a reply with the template transaction id")
+	}
+
+	ntpTemplate := presetPayload(t, "ntp")
+	ntpReq := prepareNTP(ntpTemplate, sent)
+	ntpResp := make([]byte, 48)
+	ntpResp[0], ntpResp[1] = 0x24, 2
+	copy(ntpResp[24:32], ntpTemplate[40:48])
+	if err := ValidateNTP(Exchange{Request: ntpReq, Response: ntpResp, Sent: sent, Received: sent}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateNTP() accepted a reply with the template origin timestamp")
+	}
+	if got := ntpTime(ntpReq[40:48]); !got.Equal(sent) {
+		t.Errorf("prepareNTP() transmit time = %v, expected %v", got, sent)
+	}
+	if !bytes.Equal(dnsTemplate, presetPayload(t, "dns")) || !bytes.Equal(ntpTemplate, presetPayload(t, "ntp")) {
+		t.Error("Prepare modified the template payload")
+	}
+}
+
+func TestPingUDP_PresetValidator(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// answer every query with SERVFAIL
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(dnsReply(buf[:n], 0x8182, 0), addr)
+		}
+	}()
+
+	cfg := &models.Config{
+		Proto:      models.UDP,
+		Preset:     "dns",
+		TimeoutDur: time.Second,
+	}
+	details := &models.Details{}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  cfg,
+		Address: conn.LocalAddr().String(),
+		Payload: presetPayload(t, "dns"),
+		Details: details,
+	}
+
+	_, err = PingUDP(opts)
+	var valErr *ValidationError
+	if !errors.As(err, &valErr) {
+		t.Fatalf("PingUDP() error = %v, expected ValidationError", err)
+	}
+	if details.UDP == nil || details.UDP.RCode != "SERVFAIL" || details.UDP.Bytes != len(opts.Payload) {
+		t.Errorf("PingUDP() details = %+v", details.UDP)
+	}
+
+	// without a preset any reply is accepted
+	cfg.Preset = ""
+	if _, err := PingUDP(opts); err != nil {
+		t.Errorf("PingUDP() without preset error = %v, expected nil", err)
+	}
+}
+
+func TestValidateEcho(t *testing.T) {
+	sent := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := prepareEcho(presetPayload(t, "echo"), sent)
+	received := sent.Add(10 * time.Millisecond)
+
+	info := &models.UDPInfo{}
+	if err := ValidateEcho(Exchange{Request: req, Response: req, Sent: sent, Received: received}, info); err != nil {
+		t.Fatalf("ValidateEcho() plain echo error = %v", err)
+	}
+	if info.Stamped {
+		t.Error("ValidateEcho() plain echo should not be stamped")
+	}
+
+	stamped := append([]byte{}, req...)
+	echo.Stamp(stamped, sent.Add(3*time.Millisecond), sent.Add(4*time.Millisecond))
+	info = &models.UDPInfo{}
+	if err := ValidateEcho(Exchange{Request: req, Response: stamped, Sent: sent, Received: received}, info); err != nil {
+		t.Fatalf("ValidateEcho() stamped error = %v", err)
+	}
+	if !info.Stamped || info.Forward != 3*time.Millisecond || info.Reverse != 6*time.Millisecond {
+		t.Errorf("ValidateEcho() info = %+v, expected fwd 3ms and rev 6ms", info)
+	}
+
+	other := prepareEcho(presetPayload(t, "echo"), sent)
+	if err := ValidateEcho(Exchange{Request: req, Response: other}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateEcho() expected error for the reply of another request")
+	}
+	if err := ValidateEcho(Exchange{Request: []byte("ping"), Response: []byte("pong")}, &models.UDPInfo{}); err == nil {
+		t.Error("ValidateEcho() expected error for a changed custom payload")
+	}
+}
+
+func TestPingUDP_Echo(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = echo.ServeUDP(ctx, conn, true) }()
+
+	details := &models.Details{}
+	opts := models.PingOptions{
+		Context: context.Background(),
+		Config:  &models.Config{Proto: models.UDP, Preset: "echo", TimeoutDur: time.Second},
+		Address: conn.LocalAddr().String(),
+		Payload: presetPayload(t, "echo"),
+		Details: details,
+	}
+	if _, err := PingUDP(opts); err != nil {
+		t.Fatalf("PingUDP() error = %v", err)
+	}
+	if details.UDP == nil || !details.UDP.Stamped {
+		t.Fatalf("PingUDP() details = %+v, expected a stamped reply", details.UDP)
+	}
+	// same clock on both sides
+	if details.UDP.Forward < 0 || details.UDP.Reverse < 0 {
+		t.Errorf("one-way delays fwd %s rev %s, expected >= 0", details.UDP.Forward, details.UDP.Reverse)
+	}
+}
@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/sopov/portping/internal/helpers"
+	"github.com/sopov/portping/internal/models"
+	"strconv"
+	"time"
+)
+
+// Violation is a limit exceeded by a row of the statistics table.
+type Violation struct {
+	Status models.Status
+	Label  string
+	// Metric is "loss", "avg" or "p95"; it is empty when a latency limit
+	// could not be checked because no attempt succeeded.
+	Metric string
+	Value  float64
+	Limit  float64
+}
+
+func (v Violation) String() string {
+	switch v.Metric {
+	case "":
+		return v.Label + " no successful attempts"
+	case "loss":
+		return fmt.Sprintf("%s loss %.2f%% > %s%%", v.Label, v.Value, strconv.FormatFloat(v.Limit, 'f', -1, 64))
+	}
+	return fmt.Sprintf("%s %s %s > %s", v.Label, v.Metric,
+		helpers.DurStr(msDur(v.Value)), strconv.FormatFloat(v.Limit, 'f', -1, 64)+"ms")
+}
+
+func msDur(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Check evaluates the statistics of a target against cfg.Limits and
+// returns the worst status with every violated limit. Every IP is checked
+// on its own, a Happy Eyeballs target by its race totals. A target without
+// attempts is unknown; sweeps and sessions without limits are always OK.
+func Check(cfg *models.Config, statsMap map[string]*models.Stats) (models.Status, []Violation) {
+	limits := cfg.Limits
+	if !limits.IsSet() || cfg.IsSweep() {
+		return models.StatusOK, nil
+	}
+	rows := summaryRows(cfg, statsMap)
+	if len(rows) == 0 {
+		return models.StatusUnknown, nil
+	}
+
+	status := models.StatusOK
+	var violations []Violation
+	add := func(v Violation) {
+		if v.Status == models.StatusOK {
+			return
+		}
+		status = max(status, v.Status)
+		violations = append(violations, v)
+	}
+	for _, row := range rows {
+		st := row.st
+		loss := lossPct(st)
+		add(Violation{Status: limits.Loss.Check(loss), Label: row.label, Metric: "loss", Value: loss, Limit: worst(limits.Loss, loss)})
+
+		if !limits.Avg.IsSet() && !limits.P95.IsSet() {
+			continue
+		}
+		if st.Connects == 0 {
+			add(Violation{Status: models.StatusCritical, Label: row.label})
+			continue
+		}
+		avg := helpers.Ms2Float64(average(st))
+		add(Violation{Status: limits.Avg.Check(avg), Label: row.label, Metric: "avg", Value: avg, Limit: worst(limits.Avg, avg)})
+		p95 := helpers.Ms2Float64(percentiles(st, 95)[0])
+		add(Violation{Status: limits.P95.Check(p95), Label: row.label, Metric: "p95", Value: p95, Limit: worst(limits.P95, p95)})
+	}
+	return status, violations
+}
+
+// worst returns the most severe threshold of l that v exceeds.
+func worst(l models.Limit, v float64) float64 {
+	if l.HasCrit && v > l.Crit {
+		return l.Crit
+	}
+	return l.Warn
+}
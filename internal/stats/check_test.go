@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"bytes"
+	"github.com/sopov/portping/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkStats(durations ...time.Duration) *models.Stats {
+	st := &models.Stats{}
+	for _, d := range durations {
+		var err error
+		if d == 0 {
+			err = errTest
+		}
+		Update(st, d, err)
+	}
+	return st
+}
+
+func TestCheck(t *testing.T) {
+	ip := models.IP{IP: "192.0.2.1", IsIPv4: true}
+	ms := time.Millisecond
+	tests := []struct {
+		name       string
+		limits     models.Limits
+		st         *models.Stats
+		status     models.Status
+		violations []string
+	}{
+		{
+			name:   "no limits",
+			st:     checkStats(0, 0),
+			status: models.StatusOK,
+		},
+		{
+			name:   "within limits",
+			limits: models.Limits{Loss: models.Limit{Crit: 50, HasCrit: true}, Avg: models.Limit{Warn: 20, Crit: 40, HasWarn: true, HasCrit: true}},
+			st:     checkStats(10*ms, 12*ms, 0, 14*ms),
+			status: models.StatusOK,
+		},
+		{
+			name:       "loss warning",
+			limits:     models.Limits{Loss: models.Limit{Warn: 10, Crit: 50, HasWarn: true, HasCrit: true}},
+			st:         checkStats(10*ms, 0, 10*ms, 10*ms),
+			status:     models.StatusWarning,
+			violations: []string{"192.0.2.1 loss 25.00% > 10%"},
+		},
+		{
+			name:       "latency critical",
+			limits:     models.Limits{Avg: models.Limit{Warn: 20, HasWarn: true}, P95: models.Limit{Crit: 50, HasCrit: true}},
+			st:         checkStats(10*ms, 10*ms, 10*ms, 90*ms),
+			status:     models.StatusCritical,
+			violations: []string{"192.0.2.1 avg", "192.0.2.1 p95"},
+		},
+		{
+			name:       "no connects",
+			limits:     models.Limits{Avg: models.Limit{Crit: 20, HasCrit: true}},
+			st:         checkStats(0, 0),
+			status:     models.StatusCritical,
+			violations: []string{"192.0.2.1 no successful attempts"},
+		},
+		{
+			name:   "no attempts",
+			limits: models.Limits{Loss: models.Limit{Crit: 5, HasCrit: true}},
+			st:     &models.Stats{},
+			status: models.StatusUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{IPs: []models.IP{ip}, Limits: tt.limits}
+			status, violations := Check(cfg, map[string]*models.Stats{ip.IP: tt.st})
+			if status != tt.status {
+				t.Errorf("status = %s, expected %s", status, tt.status)
+			}
+			if len(violations) != len(tt.violations) {
+				t.Fatalf("violations = %v, expected %v", violations, tt.violations)
+			}
+			for i, v := range violations {
+				if !strings.HasPrefix(v.String(), tt.violations[i]) {
+					t.Errorf("violation %q, expected %q", v, tt.violations[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTextReporter_Check(t *testing.T) {
+	ip := models.IP{IP: "192.0.2.1", IsIPv4: true}
+	cfg := &models.Config{
+		Host: "example.com", Port: "80", Proto: models.TCP, NoColor: true,
+		IPs:    []models.IP{ip},
+		Limits: models.Limits{Loss: models.Limit{Warn: 1, Crit: 40, HasWarn: true, HasCrit: true}},
+	}
+	var buf bytes.Buffer
+	NewTextReporter(&buf, false).OnSummary(cfg, map[string]*models.Stats{ip.IP: checkStats(5*time.Millisecond, 0)})
+	if out := buf.String(); !strings.Contains(out, "Check: CRITICAL\n") || !strings.Contains(out, "CRITICAL 192.0.2.1 loss 50.00% > 40%") {
+		t.Errorf("unexpected check output %q", out)
+	}
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"encoding/csv"
+	"github.com/sopov/portping/internal/helpers"
+	"github.com/sopov/portping/internal/models"
+	"io"
+	"strconv"
+)
+
+var csvHeader = []string{
+	"time", "attempt", "sub", "host", "ip", "family", "proto", "port",
+	"duration_ms", "ok", "error_class", "error", "state",
+}
+
+// CSVReporter writes one row per attempt, preceded by a header row. All
+// targets of a session share the one header.
+type CSVReporter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func NewCSVReporter(w io.Writer) *CSVReporter {
+	return &CSVReporter{w: csv.NewWriter(w)}
+}
+
+func (r *CSVReporter) OnStart(_ *models.Config) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	_ = r.w.Write(csvHeader)
+	r.w.Flush()
+}
+
+func (r *CSVReporter) OnResult(cfg *models.Config, res models.Result) {
+	errMsg := ""
+	if res.Err != nil {
+		errMsg = res.Err.Error()
+	}
+	_ = r.w.Write([]string{
+		timestamp(res.Time),
+		strconv.Itoa(res.Attempt),
+		strconv.Itoa(res.Sub),
+		cfg.Host,
+		res.IP.IP,
+		res.IP.Family(),
+		cfg.Proto.String(),
+		resultPort(cfg, res),
+		strconv.FormatFloat(helpers.Ms2Float64(res.Duration), 'f', 3, 64),
+		strconv.FormatBool(res.Err == nil),
+		res.ErrClass.String(),
+		errMsg,
+		res.State.String(),
+	})
+	r.w.Flush()
+}
+
+// OnEvent is a no-op: the CSV holds attempts only.
+func (r *CSVReporter) OnEvent(_ *models.Config, _ models.Event) {}
+
+func (r *CSVReporter) OnSummary(_ *models.Config, _ map[string]*models.Stats) {
+	r.w.Flush()
+}
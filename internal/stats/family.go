@@ -0,0 +1,117 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/sopov/portping/internal/helpers"
+	"github.com/sopov/portping/internal/models"
+	"strconv"
+	"time"
+)
+
+// familyStats merges the per-IP stats of all addresses of one family.
+// Jitter depends on the order of the samples and is not merged.
+func familyStats(cfg *models.Config, statsMap map[string]*models.Stats, ipv4 bool) *models.Stats {
+	fam := &models.Stats{}
+	for _, ip := range cfg.IPs {
+		st := statsMap[ip.IP]
+		if st == nil || ip.IsIPv4 != ipv4 {
+			continue
+		}
+		fam.Attempts += st.Attempts
+		fam.Connects += st.Connects
+		fam.Failures += st.Failures
+		fam.Total += st.Total
+		fam.SumSquares += st.SumSquares
+		fam.Samples = append(fam.Samples, st.Samples...)
+		if st.Connects > 0 && (fam.Minimum == 0 || st.Minimum < fam.Minimum) {
+			fam.Minimum = st.Minimum
+		}
+		fam.Maximum = max(fam.Maximum, st.Maximum)
+		for class, n := range st.Errors {
+			if fam.Errors == nil {
+				fam.Errors = make(map[models.ErrClass]int)
+			}
+			fam.Errors[class] += n
+		}
+	}
+	return fam
+}
+
+func lossPct(st *models.Stats) float64 {
+	if st.Attempts == 0 {
+		return 0
+	}
+	return 100 * float64(st.Failures) / float64(st.Attempts)
+}
+
+// showFamilies prints the IPv4 and IPv6 totals of a dual-stack session and
+// the difference between them, IPv6 minus IPv4.
+func (r *TextReporter) showFamilies(cfg *models.Config, statsMap map[string]*models.Stats) {
+	v4 := familyStats(cfg, statsMap, true)
+	v6 := familyStats(cfg, statsMap, false)
+	const format = "% 8s% 12s% 12s% 10s% 12s% 12s% 12s\n"
+
+	fmt.Fprintf(r.w, "\nIPv4 vs IPv6\n")
+	fmt.Fprintf(r.w, format, "Family", "Attempted", "Failed", "Loss", "Average", "P50", "P95")
+	for _, row := range []struct {
+		name string
+		st   *models.Stats
+	}{{"IPv4", v4}, {"IPv6", v6}} {
+		ps := percentiles(row.st, 50, 95)
+		fmt.Fprintf(r.w, format,
+			row.name,
+			strconv.Itoa(row.st.Attempts),
+			strconv.Itoa(row.st.Failures),
+			fmt.Sprintf("%.2f%%", lossPct(row.st)),
+			helpers.DurStr(average(row.st)),
+			helpers.DurStr(ps[0]),
+			helpers.DurStr(ps[1]),
+		)
+	}
+
+	delta := []string{"Delta", "", "", fmt.Sprintf("%+.2f%%", lossPct(v6)-lossPct(v4)), "-", "-", "-"}
+	if v4.Connects > 0 && v6.Connects > 0 {
+		p4, p6 := percentiles(v4, 50, 95), percentiles(v6, 50, 95)
+		delta[4] = deltaStr(average(v6) - average(v4))
+		delta[5] = deltaStr(p6[0] - p4[0])
+		delta[6] = deltaStr(p6[1] - p4[1])
+	}
+	fmt.Fprintf(r.w, format, delta[0], delta[1], delta[2], delta[3], delta[4], delta[5], delta[6])
+}
+
+func deltaStr(d time.Duration) string {
+	return fmt.Sprintf("%+.2fms", helpers.Ms2Float64(d))
+}
+
+// showWins prints how often each address and family won the Happy
+// Eyeballs race.
+func (r *TextReporter) showWins(cfg *models.Config, statsMap map[string]*models.Stats) {
+	race := statsMap[models.RaceKey]
+	if race == nil || race.Connects == 0 {
+		return
+	}
+	var maxLen int
+	for _, ip := range cfg.IPs {
+		if l := len(ip.IP); l > maxLen {
+			maxLen = l
+		}
+	}
+	format := "% " + strconv.Itoa(maxLen+1) + "s% 8s% 10s% 12s\n"
+	share := func(n int) string {
+		return fmt.Sprintf("%.2f%%", 100*float64(n)/float64(race.Connects))
+	}
+
+	fmt.Fprintf(r.w, "\nWins by address\n")
+	fmt.Fprintf(r.w, format, "IP Address", "Wins", "Share", "Average")
+	wins := map[bool]int{}
+	for _, ip := range cfg.IPs {
+		st := statsMap[ip.IP]
+		if st == nil {
+			continue
+		}
+		wins[ip.IsIPv4] += st.Connects
+		fmt.Fprintf(r.w, format, ip.IP, strconv.Itoa(st.Connects), share(st.Connects), helpers.DurStr(average(st)))
+	}
+	fmt.Fprintf(r.w, "\nWins by family: IPv6 %d (%s), IPv4 %d (%s)\n",
+		wins[false], share(wins[false]), wins[true], share(wins[true]))
+}
@@ -0,0 +1,518 @@
+package stats
+
+import (
+	"encoding/json"
+	"github.com/sopov/portping/internal/helpers"
+	"github.com/sopov/portping/internal/models"
+	"io"
+	"time"
+)
+
+type jsonIP struct {
+	IP     string `json:"ip"`
+	Family string `json:"family"`
+}
+
+type startEvent struct {
+	Event      string   `json:"event,omitempty"`
+	Time       string   `json:"time"`
+	Host       string   `json:"host"`
+	Proto      string   `json:"proto"`
+	Port       string   `json:"port"`
+	IPs        []jsonIP `json:"ips"`
+	PayloadHex string   `json:"payload_hex,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	TimeoutMs  float64  `json:"timeout_ms"`
+	DelayMs    float64  `json:"delay_ms"`
+	Count      int      `json:"count"`
+	// Resolution is omitted for IP literals.
+	Resolution *resolutionEvent `json:"resolution,omitempty"`
+	SRV        *srvEvent        `json:"srv,omitempty"`
+}
+
+// srvEvent is the SRV record a target was discovered from.
+type srvEvent struct {
+	Name     string `json:"name"`
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+}
+
+func newSRVEvent(srv *models.SRV) *srvEvent {
+	if srv == nil {
+		return nil
+	}
+	return &srvEvent{Name: srv.Name, Priority: srv.Priority, Weight: srv.Weight}
+}
+
+type resolutionEvent struct {
+	Server     string   `json:"server,omitempty"`
+	TCP        bool     `json:"tcp,omitempty"`
+	DurationMs float64  `json:"duration_ms"`
+	CNAMEs     []string `json:"cnames,omitempty"`
+}
+
+type tlsEvent struct {
+	HandshakeMs float64 `json:"handshake_ms"`
+	Version     string  `json:"version"`
+	CipherSuite string  `json:"cipher_suite"`
+	ALPN        string  `json:"alpn,omitempty"`
+	Subject     string  `json:"subject,omitempty"`
+	NotAfter    string  `json:"not_after,omitempty"`
+}
+
+type httpEvent struct {
+	Status int     `json:"status"`
+	Proto  string  `json:"proto"`
+	TTFBMs float64 `json:"ttfb_ms"`
+	Bytes  int64   `json:"bytes"`
+}
+
+type udpEvent struct {
+	Bytes    int     `json:"bytes"`
+	RCode    string  `json:"rcode,omitempty"`
+	Answers  int     `json:"answers,omitempty"`
+	Stratum  int     `json:"stratum,omitempty"`
+	OffsetMs float64 `json:"offset_ms,omitempty"`
+	Mapped   string  `json:"mapped,omitempty"`
+	// one-way delays from a stamping echo reflector
+	ForwardMs *float64 `json:"forward_ms,omitempty"`
+	ReverseMs *float64 `json:"reverse_ms,omitempty"`
+}
+
+type resultEvent struct {
+	Event      string     `json:"event,omitempty"`
+	Time       string     `json:"time"`
+	Attempt    int        `json:"attempt"`
+	Sub        int        `json:"sub"`
+	Host       string     `json:"host"`
+	IP         string     `json:"ip"`
+	Family     string     `json:"family"`
+	Proto      string     `json:"proto"`
+	Port       string     `json:"port"`
+	DurationMs float64    `json:"duration_ms"`
+	OK         bool       `json:"ok"`
+	Error      string     `json:"error,omitempty"`
+	ErrorClass string     `json:"error_class,omitempty"`
+	State      string     `json:"state,omitempty"`
+	ConnectMs  float64    `json:"connect_ms,omitempty"`
+	TLS        *tlsEvent  `json:"tls,omitempty"`
+	HTTP       *httpEvent `json:"http,omitempty"`
+	UDP        *udpEvent  `json:"udp,omitempty"`
+	Banner     string     `json:"banner,omitempty"`
+	Warning    string     `json:"warning,omitempty"`
+}
+
+type ipSummary struct {
+	IP            string             `json:"ip,omitempty"`
+	Family        string             `json:"family,omitempty"`
+	Attempted     int                `json:"attempted"`
+	Connected     int                `json:"connected"`
+	Failed        int                `json:"failed"`
+	LossPct       float64            `json:"loss_pct"`
+	MinMs         float64            `json:"min_ms"`
+	MaxMs         float64            `json:"max_ms"`
+	AvgMs         float64            `json:"avg_ms"`
+	MdevMs        float64            `json:"mdev_ms"`
+	JitterMs      float64            `json:"jitter_ms"`
+	PercentilesMs map[string]float64 `json:"percentiles_ms"`
+	Errors        map[string]int     `json:"errors,omitempty"`
+	States        map[string]int     `json:"states,omitempty"`
+	Ports         map[string]string  `json:"ports,omitempty"`
+	Retired       bool               `json:"retired,omitempty"`
+	TWAMP         *twampSummary      `json:"twamp,omitempty"`
+}
+
+// twampSummary holds the counters of a two-way echo session.
+type twampSummary struct {
+	Sent            int     `json:"sent"`
+	Received        int     `json:"received"`
+	Reflected       int     `json:"reflected"`
+	ForwardLoss     int     `json:"forward_loss"`
+	ReverseLoss     int     `json:"reverse_loss"`
+	UnknownLoss     int     `json:"unknown_loss"`
+	Reordered       int     `json:"reordered"`
+	Duplicates      int     `json:"duplicates"`
+	Late            int     `json:"late"`
+	ForwardJitterMs float64 `json:"forward_jitter_ms"`
+	ReverseJitterMs float64 `json:"reverse_jitter_ms"`
+}
+
+type familySummary struct {
+	Family    string  `json:"family"`
+	Attempted int     `json:"attempted"`
+	Connected int     `json:"connected"`
+	Failed    int     `json:"failed"`
+	LossPct   float64 `json:"loss_pct"`
+	AvgMs     float64 `json:"avg_ms"`
+	P50Ms     float64 `json:"p50_ms"`
+	P95Ms     float64 `json:"p95_ms"`
+}
+
+// raceSummary holds the totals of a Happy Eyeballs session and how often
+// each address and family won.
+type raceSummary struct {
+	ipSummary
+	Wins       map[string]int `json:"wins"`
+	FamilyWins map[string]int `json:"family_wins"`
+}
+
+// familyDelta is IPv6 minus IPv4; latency deltas are omitted unless both
+// families had successful attempts.
+type familyDelta struct {
+	LossPct float64  `json:"loss_pct"`
+	AvgMs   *float64 `json:"avg_ms,omitempty"`
+	P50Ms   *float64 `json:"p50_ms,omitempty"`
+	P95Ms   *float64 `json:"p95_ms,omitempty"`
+}
+
+type summaryEvent struct {
+	Event    string          `json:"event,omitempty"`
+	Time     string          `json:"time"`
+	Host     string          `json:"host"`
+	Proto    string          `json:"proto"`
+	Port     string          `json:"port"`
+	IPs      []ipSummary     `json:"ips"`
+	Families []familySummary `json:"families,omitempty"`
+	Delta    *familyDelta    `json:"delta,omitempty"`
+	Race     *raceSummary    `json:"race,omitempty"`
+	SRV      *srvEvent       `json:"srv,omitempty"`
+	Stop     string          `json:"stop_reason,omitempty"`
+	// Status and Violations are only set when limits are given.
+	Status     string   `json:"status,omitempty"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+type resolveEvent struct {
+	Event   string   `json:"event,omitempty"`
+	Time    string   `json:"time"`
+	Host    string   `json:"host"`
+	Added   []jsonIP `json:"added,omitempty"`
+	Removed []jsonIP `json:"removed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type jsonDocument struct {
+	Start   *startEvent    `json:"start"`
+	Results []resultEvent  `json:"results"`
+	Events  []resolveEvent `json:"events,omitempty"`
+	Summary *summaryEvent  `json:"summary"`
+}
+
+// jsonSession is the document of a session with several targets.
+type jsonSession struct {
+	Targets []*jsonDocument `json:"targets"`
+}
+
+// JSONReporter renders a session as JSON. In stream mode every event is
+// written as a separate line (NDJSON) as soon as it happens, otherwise the
+// events are collected and written as a single document once every target
+// got its summary. With several targets the document holds a "targets"
+// array of per-target documents.
+type JSONReporter struct {
+	enc    *json.Encoder
+	stream bool
+	docs   []*jsonDocument
+	byCfg  map[*models.Config]*jsonDocument
+}
+
+func NewJSONReporter(w io.Writer, stream bool) *JSONReporter {
+	enc := json.NewEncoder(w)
+	if !stream {
+		enc.SetIndent("", "  ")
+	}
+	return &JSONReporter{enc: enc, stream: stream}
+}
+
+func (o *JSONReporter) OnStart(cfg *models.Config) {
+	ev := &startEvent{
+		Time:       timestamp(time.Now()),
+		Host:       cfg.Host,
+		Proto:      cfg.Proto.String(),
+		Port:       cfg.Port,
+		IPs:        jsonIPs(cfg.IPs),
+		PayloadHex: cfg.UDPPayloadHex,
+		Source:     cfg.SourceName(),
+		TimeoutMs:  helpers.Ms2Float64(cfg.TimeoutDur),
+		DelayMs:    helpers.Ms2Float64(cfg.DelayDur),
+		Count:      cfg.Count,
+		SRV:        newSRVEvent(cfg.SRV),
+	}
+	if res := cfg.Resolution; res != nil {
+		ev.Resolution = &resolutionEvent{
+			Server:     res.Server,
+			TCP:        cfg.ResolverTCP,
+			DurationMs: helpers.Ms2Float64(res.Duration),
+			CNAMEs:     res.CNAMEs,
+		}
+	}
+	if o.stream {
+		ev.Event = "start"
+		_ = o.enc.Encode(ev)
+		return
+	}
+	o.doc(cfg).Start = ev
+}
+
+// doc returns the document collecting the events of the target cfg.
+func (o *JSONReporter) doc(cfg *models.Config) *jsonDocument {
+	if d, ok := o.byCfg[cfg]; ok {
+		return d
+	}
+	if o.byCfg == nil {
+		o.byCfg = make(map[*models.Config]*jsonDocument)
+	}
+	d := &jsonDocument{Results: []resultEvent{}}
+	o.byCfg[cfg] = d
+	o.docs = append(o.docs, d)
+	return d
+}
+
+func (o *JSONReporter) OnResult(cfg *models.Config, r models.Result) {
+	ev := resultEvent{
+		Time:       timestamp(r.Time),
+		Attempt:    r.Attempt,
+		Sub:        r.Sub,
+		Host:       cfg.Host,
+		IP:         r.IP.IP,
+		Family:     r.IP.Family(),
+		Proto:      cfg.Proto.String(),
+		Port:       resultPort(cfg, r),
+		DurationMs: helpers.Ms2Float64(r.Duration),
+		OK:         r.Err == nil,
+		ErrorClass: r.ErrClass.String(),
+		State:      r.State.String(),
+	}
+	if r.Err != nil {
+		ev.Error = r.Err.Error()
+	}
+	if d := r.Details; d != nil {
+		ev.ConnectMs = helpers.Ms2Float64(d.Connect)
+		ev.Warning = d.Warning
+		ev.Banner = d.Banner
+		if d.HTTP != nil {
+			ev.HTTP = &httpEvent{
+				Status: d.HTTP.Status,
+				Proto:  d.HTTP.Proto,
+				TTFBMs: helpers.Ms2Float64(d.HTTP.TTFB),
+				Bytes:  d.HTTP.Bytes,
+			}
+		}
+		if d.UDP != nil {
+			ev.UDP = &udpEvent{
+				Bytes:    d.UDP.Bytes,
+				RCode:    d.UDP.RCode,
+				Answers:  d.UDP.Answers,
+				Stratum:  d.UDP.Stratum,
+				OffsetMs: helpers.Ms2Float64(d.UDP.Offset),
+				Mapped:   d.UDP.Mapped,
+			}
+			if d.UDP.Stamped {
+				fwd, rev := helpers.Ms2Float64(d.UDP.Forward), helpers.Ms2Float64(d.UDP.Reverse)
+				ev.UDP.ForwardMs, ev.UDP.ReverseMs = &fwd, &rev
+			}
+		}
+		if d.TLS != nil {
+			ev.TLS = &tlsEvent{
+				HandshakeMs: helpers.Ms2Float64(d.TLS.Handshake),
+				Version:     d.TLS.Version,
+				CipherSuite: d.TLS.CipherSuite,
+				ALPN:        d.TLS.ALPN,
+				Subject:     d.TLS.Subject,
+			}
+			if !d.TLS.NotAfter.IsZero() {
+				ev.TLS.NotAfter = timestamp(d.TLS.NotAfter)
+			}
+		}
+	}
+	if o.stream {
+		ev.Event = "result"
+		_ = o.enc.Encode(ev)
+		return
+	}
+	d := o.doc(cfg)
+	d.Results = append(d.Results, ev)
+}
+
+func (o *JSONReporter) OnEvent(cfg *models.Config, ev models.Event) {
+	if ev.Kind != models.EventResolve {
+		return
+	}
+	out := resolveEvent{
+		Time:    timestamp(ev.Time),
+		Host:    cfg.Host,
+		Added:   jsonIPs(ev.Added),
+		Removed: jsonIPs(ev.Removed),
+	}
+	if ev.Err != nil {
+		out.Error = ev.Err.Error()
+	}
+	if o.stream {
+		out.Event = ev.Kind.String()
+		_ = o.enc.Encode(out)
+		return
+	}
+	d := o.doc(cfg)
+	d.Events = append(d.Events, out)
+}
+
+func jsonIPs(ips []models.IP) []jsonIP {
+	out := make([]jsonIP, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, jsonIP{IP: ip.IP, Family: ip.Family()})
+	}
+	return out
+}
+
+func (o *JSONReporter) OnSummary(cfg *models.Config, statsMap map[string]*models.Stats) {
+	ev := &summaryEvent{
+		Time:  timestamp(time.Now()),
+		Host:  cfg.Host,
+		Proto: cfg.Proto.String(),
+		Port:  cfg.Port,
+		IPs:   make([]ipSummary, 0, len(cfg.IPs)),
+		SRV:   newSRVEvent(cfg.SRV),
+		Stop:  string(cfg.Stop),
+	}
+	for _, ip := range cfg.IPs {
+		st := statsMap[ip.IP]
+		if st == nil || st.Attempts == 0 || cfg.HappyEyeballs {
+			continue
+		}
+		ev.IPs = append(ev.IPs, newIPSummary(ip, st))
+	}
+	if race := statsMap[models.RaceKey]; cfg.HappyEyeballs && race != nil && race.Attempts > 0 {
+		ev.Race = &raceSummary{
+			ipSummary:  newIPSummary(models.IP{}, race),
+			Wins:       make(map[string]int),
+			FamilyWins: map[string]int{"ipv4": 0, "ipv6": 0},
+		}
+		for _, ip := range cfg.IPs {
+			if st := statsMap[ip.IP]; st != nil {
+				ev.Race.Wins[ip.IP] = st.Connects
+				ev.Race.FamilyWins[ip.Family()] += st.Connects
+			}
+		}
+	}
+	if cfg.Dual && !cfg.HappyEyeballs {
+		ev.Families, ev.Delta = familiesSummary(cfg, statsMap)
+	}
+	if cfg.Limits.IsSet() {
+		status, violations := Check(cfg, statsMap)
+		ev.Status = status.String()
+		for _, v := range violations {
+			ev.Violations = append(ev.Violations, v.Status.String()+" "+v.String())
+		}
+	}
+	if o.stream {
+		ev.Event = "summary"
+		_ = o.enc.Encode(ev)
+		return
+	}
+	o.doc(cfg).Summary = ev
+	for _, d := range o.docs {
+		if d.Summary == nil {
+			return
+		}
+	}
+	if len(o.docs) == 1 {
+		_ = o.enc.Encode(o.docs[0])
+		return
+	}
+	_ = o.enc.Encode(jsonSession{Targets: o.docs})
+}
+
+func newIPSummary(ip models.IP, st *models.Stats) ipSummary {
+	ps := make(map[string]float64, len(Percentiles))
+	for i, d := range percentiles(st, Percentiles...) {
+		ps[percentileKey(Percentiles[i])] = helpers.Ms2Float64(d)
+	}
+	var errs map[string]int
+	if len(st.Errors) > 0 {
+		errs = make(map[string]int, len(st.Errors))
+		for class, n := range st.Errors {
+			errs[class.String()] = n
+		}
+	}
+	var states map[string]int
+	var ports map[string]string
+	if len(st.States) > 0 {
+		states = make(map[string]int, len(st.States))
+		for state, n := range st.States {
+			states[state.String()] = n
+		}
+		ports = make(map[string]string, len(st.Ports))
+		for port, state := range st.Ports {
+			ports[port] = state.String()
+		}
+	}
+	sum := ipSummary{
+		IP:            ip.IP,
+		Attempted:     st.Attempts,
+		Connected:     st.Connects,
+		Failed:        st.Failures,
+		LossPct:       lossPct(st),
+		MinMs:         helpers.Ms2Float64(st.Minimum),
+		MaxMs:         helpers.Ms2Float64(st.Maximum),
+		AvgMs:         helpers.Ms2Float64(average(st)),
+		MdevMs:        helpers.Ms2Float64(Mdev(st)),
+		JitterMs:      helpers.Ms2Float64(st.Jitter),
+		PercentilesMs: ps,
+		Errors:        errs,
+		States:        states,
+		Ports:         ports,
+		Retired:       st.Retired,
+	}
+	if ip.IP != "" {
+		sum.Family = ip.Family()
+	}
+	if seq := st.Seq; seq != nil {
+		sum.TWAMP = &twampSummary{
+			Sent:            seq.Sent,
+			Received:        seq.Received,
+			Reflected:       seq.Reflected,
+			ForwardLoss:     seq.ForwardLoss,
+			ReverseLoss:     seq.ReverseLoss,
+			UnknownLoss:     seq.Unknown,
+			Reordered:       seq.Reordered,
+			Duplicates:      seq.Duplicates,
+			Late:            seq.Late,
+			ForwardJitterMs: helpers.Ms2Float64(seq.ForwardJitter),
+			ReverseJitterMs: helpers.Ms2Float64(seq.ReverseJitter),
+		}
+	}
+	return sum
+}
+
+func familiesSummary(cfg *models.Config, statsMap map[string]*models.Stats) ([]familySummary, *familyDelta) {
+	v4 := familyStats(cfg, statsMap, true)
+	v6 := familyStats(cfg, statsMap, false)
+	families := make([]familySummary, 0, 2)
+	for _, fam := range []struct {
+		name string
+		st   *models.Stats
+	}{{"ipv4", v4}, {"ipv6", v6}} {
+		ps := percentiles(fam.st, 50, 95)
+		families = append(families, familySummary{
+			Family:    fam.name,
+			Attempted: fam.st.Attempts,
+			Connected: fam.st.Connects,
+			Failed:    fam.st.Failures,
+			LossPct:   lossPct(fam.st),
+			AvgMs:     helpers.Ms2Float64(average(fam.st)),
+			P50Ms:     helpers.Ms2Float64(ps[0]),
+			P95Ms:     helpers.Ms2Float64(ps[1]),
+		})
+	}
+	delta := &familyDelta{LossPct: families[1].LossPct - families[0].LossPct}
+	if v4.Connects > 0 && v6.Connects > 0 {
+		avg := families[1].AvgMs - families[0].AvgMs
+		p50 := families[1].P50Ms - families[0].P50Ms
+		p95 := families[1].P95Ms - families[0].P95Ms
+		delta.AvgMs, delta.P50Ms, delta.P95Ms = &avg, &p50, &p95
+	}
+	return families, delta
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
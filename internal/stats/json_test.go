@@ -0,0 +1,290 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/sopov/portping/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func jsonTestConfig() *models.Config {
+	return &models.Config{
+		Host:       "example.com",
+		Port:       "80",
+		Proto:      models.TCP,
+		TimeoutDur: time.Second,
+		DelayDur:   time.Second,
+		IPs: []models.IP{
+			{IP: "192.168.1.1", IsIPv4: true},
+			{IP: "::1", IsIPv4: false},
+		},
+	}
+}
+
+func TestJSONReporter_Stream(t *testing.T) {
+	cfg := jsonTestConfig()
+	var buf bytes.Buffer
+	out := NewJSONReporter(&buf, true)
+
+	statsMap := map[string]*models.Stats{
+		"192.168.1.1": {IP: cfg.IPs[0]},
+		"::1":         {IP: cfg.IPs[1]},
+	}
+
+	out.OnStart(cfg)
+	Update(statsMap["192.168.1.1"], 10*time.Millisecond, nil)
+	out.OnResult(cfg, models.Result{Attempt: 1, Sub: 1, IP: cfg.IPs[0], Time: time.Now(), Duration: 10 * time.Millisecond})
+	failed := models.Result{Attempt: 1, Sub: 2, IP: cfg.IPs[1], Time: time.Now(), Duration: 100 * time.Millisecond, Err: errors.New("timeout"), ErrClass: models.ErrTimeout}
+	Add(statsMap["::1"], failed)
+	out.OnResult(cfg, failed)
+	out.OnSummary(cfg, statsMap)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 NDJSON lines, got %d: %q", len(lines), buf.String())
+	}
+
+	events := make([]map[string]any, 0, len(lines))
+	for _, line := range lines {
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+
+	wantEvents := []string{"start", "result", "result", "summary"}
+	for i, want := range wantEvents {
+		if events[i]["event"] != want {
+			t.Errorf("line %d: event = %v, expected %q", i, events[i]["event"], want)
+		}
+	}
+	if events[1]["ok"] != true || events[1]["family"] != "ipv4" {
+		t.Errorf("unexpected success result: %v", events[1])
+	}
+	if events[2]["ok"] != false || events[2]["error_class"] != "timeout" || events[2]["family"] != "ipv6" {
+		t.Errorf("unexpected failed result: %v", events[2])
+	}
+	ips, ok := events[3]["ips"].([]any)
+	if !ok || len(ips) != 2 {
+		t.Fatalf("summary ips = %v, expected 2 entries", events[3]["ips"])
+	}
+	if loss := ips[1].(map[string]any)["loss_pct"]; loss != 100.0 {
+		t.Errorf("summary loss_pct = %v, expected 100", loss)
+	}
+	if errs, _ := ips[1].(map[string]any)["errors"].(map[string]any); errs["timeout"] != 1.0 {
+		t.Errorf("summary errors = %v, expected timeout: 1", ips[1].(map[string]any)["errors"])
+	}
+}
+
+func TestJSONReporter_Document(t *testing.T) {
+	cfg := jsonTestConfig()
+	var buf bytes.Buffer
+	out := NewJSONReporter(&buf, false)
+
+	statsMap := map[string]*models.Stats{"192.168.1.1": {IP: cfg.IPs[0]}}
+
+	out.OnStart(cfg)
+	if buf.Len() != 0 {
+		t.Fatalf("document mode should not write before summary, got %q", buf.String())
+	}
+	Update(statsMap["192.168.1.1"], 10*time.Millisecond, nil)
+	out.OnResult(cfg, models.Result{Attempt: 1, IP: cfg.IPs[0], Time: time.Now(), Duration: 10 * time.Millisecond})
+	out.OnSummary(cfg, statsMap)
+
+	var doc struct {
+		Start   map[string]any   `json:"start"`
+		Results []map[string]any `json:"results"`
+		Summary map[string]any   `json:"summary"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON document: %v", err)
+	}
+	if doc.Start["host"] != "example.com" {
+		t.Errorf("start host = %v, expected example.com", doc.Start["host"])
+	}
+	if len(doc.Results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(doc.Results))
+	}
+	if doc.Summary == nil {
+		t.Error("expected summary in document")
+	}
+}
+
+func TestJSONReporter_DocumentMultipleTargets(t *testing.T) {
+	first, second := jsonTestConfig(), jsonTestConfig()
+	second.Host = "example.org"
+	var buf bytes.Buffer
+	out := NewJSONReporter(&buf, false)
+
+	out.OnStart(first)
+	out.OnStart(second)
+	out.OnResult(second, models.Result{Attempt: 1, IP: second.IPs[0], Time: time.Now()})
+	out.OnSummary(first, nil)
+	if buf.Len() != 0 {
+		t.Fatalf("document should wait for the summary of every target, got %q", buf.String())
+	}
+	out.OnSummary(second, nil)
+
+	var doc struct {
+		Targets []struct {
+			Start   map[string]any   `json:"start"`
+			Results []map[string]any `json:"results"`
+		} `json:"targets"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON document: %v", err)
+	}
+	if len(doc.Targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(doc.Targets))
+	}
+	if doc.Targets[1].Start["host"] != "example.org" || len(doc.Targets[1].Results) != 1 {
+		t.Errorf("unexpected second target %+v", doc.Targets[1])
+	}
+	if len(doc.Targets[0].Results) != 0 {
+		t.Errorf("unexpected results for first target %+v", doc.Targets[0].Results)
+	}
+}
+
+func TestJSONReporter_DualStack(t *testing.T) {
+	cfg := jsonTestConfig()
+	cfg.Dual = true
+	statsMap := map[string]*models.Stats{"192.168.1.1": {IP: cfg.IPs[0]}, "::1": {IP: cfg.IPs[1]}}
+	Update(statsMap["192.168.1.1"], 10*time.Millisecond, nil)
+	Update(statsMap["::1"], 0, errors.New("timeout"))
+
+	var buf bytes.Buffer
+	NewJSONReporter(&buf, true).OnSummary(cfg, statsMap)
+
+	var ev struct {
+		Families []map[string]any `json:"families"`
+		Delta    map[string]any   `json:"delta"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(ev.Families) != 2 || ev.Families[1]["family"] != "ipv6" {
+		t.Fatalf("unexpected families %v", ev.Families)
+	}
+	if ev.Delta["loss_pct"] != 100.0 {
+		t.Errorf("delta loss = %v, expected 100", ev.Delta["loss_pct"])
+	}
+	if _, ok := ev.Delta["avg_ms"]; ok {
+		t.Error("latency delta should be omitted without IPv6 replies")
+	}
+}
+
+func TestJSONReporter_ResolveEvent(t *testing.T) {
+	cfg := jsonTestConfig()
+	var buf bytes.Buffer
+	out := NewJSONReporter(&buf, true)
+
+	out.OnEvent(cfg, models.Event{
+		Kind:    models.EventResolve,
+		Time:    time.Now(),
+		Removed: []models.IP{cfg.IPs[1]},
+	})
+	var ev map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if ev["event"] != "resolve" || ev["host"] != "example.com" {
+		t.Errorf("unexpected event %v", ev)
+	}
+	if removed, ok := ev["removed"].([]any); !ok || len(removed) != 1 {
+		t.Errorf("expected one removed IP, got %v", ev["removed"])
+	}
+	if _, ok := ev["added"]; ok {
+		t.Error("empty added list should be omitted")
+	}
+}
+
+func TestJSONReporter_Resolution(t *testing.T) {
+	cfg := jsonTestConfig()
+	cfg.Resolver = "10.0.0.53:53"
+	cfg.Resolution = &models.Resolution{
+		Server:   cfg.Resolver,
+		Duration: 1500 * time.Microsecond,
+		CNAMEs:   []string{"edge.example.net"},
+	}
+	var buf bytes.Buffer
+	NewJSONReporter(&buf, true).OnStart(cfg)
+
+	var ev struct {
+		Resolution map[string]any `json:"resolution"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	res := ev.Resolution
+	if res["server"] != "10.0.0.53:53" || res["duration_ms"] != 1.5 {
+		t.Errorf("unexpected resolution %v", res)
+	}
+	if cnames, ok := res["cnames"].([]any); !ok || len(cnames) != 1 || cnames[0] != "edge.example.net" {
+		t.Errorf("unexpected cnames %v", res["cnames"])
+	}
+
+	buf.Reset()
+	cfg.Resolution = nil
+	NewJSONReporter(&buf, true).OnStart(cfg)
+	if strings.Contains(buf.String(), "resolution") {
+		t.Errorf("resolution should be omitted for IP literals: %s", buf.String())
+	}
+}
+
+func TestJSONReporter_SRVAndStopReason(t *testing.T) {
+	cfg := jsonTestConfig()
+	cfg.SRV = &models.SRV{Name: "_http._tcp.example.com", Priority: 10, Weight: 5}
+	var buf bytes.Buffer
+	out := NewJSONReporter(&buf, true)
+	out.OnStart(cfg)
+	cfg.Stop = models.StopFailures
+	out.OnSummary(cfg, map[string]*models.Stats{})
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev struct {
+			Event string         `json:"event"`
+			SRV   map[string]any `json:"srv"`
+			Stop  string         `json:"stop_reason"`
+		}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if ev.SRV["name"] != "_http._tcp.example.com" || ev.SRV["priority"] != 10.0 || ev.SRV["weight"] != 5.0 {
+			t.Errorf("unexpected srv in %s event: %v", ev.Event, ev.SRV)
+		}
+		if ev.Event == "summary" && ev.Stop != "failures" {
+			t.Errorf("unexpected stop_reason %q", ev.Stop)
+		}
+	}
+}
+
+func TestJSONReporter_Percentiles(t *testing.T) {
+	original := Percentiles
+	defer func() { Percentiles = original }()
+	Percentiles = []float64{50, 99.9}
+
+	cfg := jsonTestConfig()
+	cfg.IPs = cfg.IPs[:1]
+	st := &models.Stats{IP: cfg.IPs[0]}
+	Update(st, 10*time.Millisecond, nil)
+	var buf bytes.Buffer
+	NewJSONReporter(&buf, true).OnSummary(cfg, map[string]*models.Stats{cfg.IPs[0].IP: st})
+
+	var ev struct {
+		IPs []struct {
+			PercentilesMs map[string]float64 `json:"percentiles_ms"`
+		} `json:"ips"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"p50": 10, "p99.9": 10}
+	if len(ev.IPs) != 1 || !reflect.DeepEqual(ev.IPs[0].PercentilesMs, want) {
+		t.Errorf("percentiles_ms = %+v, expected %v", ev.IPs, want)
+	}
+}
@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"github.com/sopov/portping/internal/models"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// MaxSamples bounds the per-IP sample buffer so that nonstop sessions run
+// in constant memory. Beyond it the buffer is a uniform reservoir sample and
+// percentiles become estimates.
+const MaxSamples = 10000
+
+// Percentiles reported in summaries.
+var Percentiles = []float64{50, 90, 95, 99}
+
+// percentileKey names percentile p in JSON summaries, e.g. "p99.9".
+func percentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func addSample(st *models.Stats, d time.Duration) {
+	if len(st.Samples) < MaxSamples {
+		st.Samples = append(st.Samples, d)
+		return
+	}
+	// Algorithm R: keep each new sample with probability MaxSamples/Connects
+	if i := rand.IntN(st.Connects); i < MaxSamples {
+		st.Samples[i] = d
+	}
+}
+
+func updateJitter(st *models.Stats, d time.Duration) {
+	if st.Connects > 1 {
+		diff := math.Abs(float64(d - st.Last))
+		st.Jitter += time.Duration((diff - float64(st.Jitter)) / 16)
+	}
+	st.Last = d
+}
+
+// Mdev returns the standard deviation of successful RTTs, as ping's mdev.
+func Mdev(st *models.Stats) time.Duration {
+	if st.Connects == 0 {
+		return 0
+	}
+	n := float64(st.Connects)
+	mean := float64(st.Total) / n
+	variance := st.SumSquares/n - mean*mean
+	if variance <= 0 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Percentile returns the nearest-rank p-th percentile of the samples.
+func Percentile(st *models.Stats, p float64) time.Duration {
+	return percentiles(st, p)[0]
+}
+
+func percentiles(st *models.Stats, ps ...float64) []time.Duration {
+	out := make([]time.Duration, len(ps))
+	if len(st.Samples) == 0 {
+		return out
+	}
+	sorted := slices.Clone(st.Samples)
+	slices.Sort(sorted)
+	for i, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		rank = min(max(rank, 1), len(sorted))
+		out[i] = sorted[rank-1]
+	}
+	return out
+}
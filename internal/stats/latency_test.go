@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"errors"
+	"github.com/sopov/portping/internal/models"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("timeout")
+
+func TestPercentile(t *testing.T) {
+	s := &models.Stats{}
+	for i := 1; i <= 100; i++ {
+		Update(s, time.Duration(i)*time.Millisecond, nil)
+	}
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0, 1 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := Percentile(s, tt.p); got != tt.expected {
+			t.Errorf("Percentile(%v) = %v, expected %v", tt.p, got, tt.expected)
+		}
+	}
+
+	if got := Percentile(&models.Stats{}, 50); got != 0 {
+		t.Errorf("Percentile() on empty stats = %v, expected 0", got)
+	}
+}
+
+func TestMdev(t *testing.T) {
+	s := &models.Stats{}
+	if got := Mdev(s); got != 0 {
+		t.Errorf("Mdev() on empty stats = %v, expected 0", got)
+	}
+
+	// mean 20ms, deviations -10/0/+10 -> sqrt(200/3) ms
+	for _, d := range []time.Duration{10, 20, 30} {
+		Update(s, d*time.Millisecond, nil)
+	}
+	got := Mdev(s)
+	if got < 8160*time.Microsecond || got > 8170*time.Microsecond {
+		t.Errorf("Mdev() = %v, expected ~8.165ms", got)
+	}
+
+	// failures do not contribute
+	Update(s, time.Second, errTest)
+	if Mdev(s) != got {
+		t.Errorf("Mdev() changed after failure: %v -> %v", got, Mdev(s))
+	}
+}
+
+func TestJitter(t *testing.T) {
+	s := &models.Stats{}
+
+	Update(s, 10*time.Millisecond, nil)
+	if s.Jitter != 0 {
+		t.Errorf("Jitter after first sample = %v, expected 0", s.Jitter)
+	}
+
+	// |26-10| = 16ms -> J = 16/16 = 1ms
+	Update(s, 26*time.Millisecond, nil)
+	if s.Jitter != time.Millisecond {
+		t.Errorf("Jitter = %v, expected 1ms", s.Jitter)
+	}
+
+	// |26-26| = 0 -> J = 1 - 1/16 ms
+	Update(s, 26*time.Millisecond, nil)
+	if expected := time.Millisecond - time.Millisecond/16; s.Jitter != expected {
+		t.Errorf("Jitter = %v, expected %v", s.Jitter, expected)
+	}
+}
+
+func TestSamplesBounded(t *testing.T) {
+	s := &models.Stats{}
+	for i := 0; i < MaxSamples+500; i++ {
+		Update(s, time.Duration(i)*time.Microsecond, nil)
+	}
+	if len(s.Samples) != MaxSamples {
+		t.Errorf("len(Samples) = %d, expected %d", len(s.Samples), MaxSamples)
+	}
+	if s.Connects != MaxSamples+500 {
+		t.Errorf("Connects = %d, expected %d", s.Connects, MaxSamples+500)
+	}
+}
@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/sopov/portping/internal/helpers"
+	"github.com/sopov/portping/internal/models"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NagiosReporter writes the session as a single monitoring plugin status
+// line with performance data once every target got its summary:
+//
+//	PORTPING OK - example.com:443/tcp loss 0.00%, rta 12.35ms | rta=12.345ms;50.000;100.000;0; pl=0%;1;5;0;100 ...
+//
+// The status is the worst result of the -max-* limits, or UNKNOWN when
+// no attempt was made.
+type NagiosReporter struct {
+	w         io.Writer
+	targets   int
+	summaries int
+	status    models.Status
+	parts     []string
+	fails     []string
+	perf      []string
+}
+
+func NewNagiosReporter(w io.Writer) *NagiosReporter {
+	return &NagiosReporter{w: w}
+}
+
+// Status returns the plugin state of the written status line.
+func (r *NagiosReporter) Status() models.Status {
+	return r.status
+}
+
+func (r *NagiosReporter) OnStart(_ *models.Config) {
+	r.targets++
+}
+
+func (r *NagiosReporter) OnResult(_ *models.Config, _ models.Result) {}
+
+func (r *NagiosReporter) OnEvent(_ *models.Config, _ models.Event) {}
+
+func (r *NagiosReporter) OnSummary(cfg *models.Config, statsMap map[string]*models.Stats) {
+	r.summaries++
+	if !cfg.IsSweep() {
+		r.add(cfg, statsMap)
+	}
+	if r.summaries < r.targets {
+		return
+	}
+
+	text := strings.Join(r.parts, "; ")
+	if len(r.fails) > 0 {
+		text = strings.Join(r.fails, ", ")
+	}
+	if text == "" {
+		r.status = models.StatusUnknown
+		text = "no attempts"
+	}
+	fmt.Fprintf(r.w, "PORTPING %s - %s | %s\n", r.status, text, strings.Join(r.perf, " "))
+}
+
+// add accounts the summary of a target: its status, a short text and the
+// performance data of the target and of every row of its table.
+func (r *NagiosReporter) add(cfg *models.Config, statsMap map[string]*models.Stats) {
+	rows := summaryRows(cfg, statsMap)
+	if len(rows) == 0 {
+		r.status = models.StatusUnknown
+		return
+	}
+	status, violations := Check(cfg, statsMap)
+	r.status = max(r.status, status)
+
+	prefix, perfPrefix := "", ""
+	if r.targets > 1 {
+		prefix, perfPrefix = cfg.Name()+" ", cfg.Name()+"_"
+	}
+	for _, v := range violations {
+		r.fails = append(r.fails, prefix+v.String())
+	}
+
+	total := &models.Stats{}
+	for _, row := range rows {
+		mergeStats(total, row.st)
+	}
+	loss := lossPct(total)
+	r.parts = append(r.parts, fmt.Sprintf("%s loss %.2f%%, rta %s", cfg.Name(), loss, rtaStr(total)))
+
+	limits := cfg.Limits
+	r.perf = append(r.perf, nagiosPerf(perfPrefix, total, limits)...)
+	if len(rows) > 1 {
+		for _, row := range rows {
+			label := strings.TrimSuffix(row.label, " (retired)")
+			r.perf = append(r.perf, nagiosPerf(perfPrefix+label+"_", row.st, limits)[:2]...)
+		}
+	}
+}
+
+// mergeStats adds the counters of st to total.
+func mergeStats(total, st *models.Stats) {
+	total.Attempts += st.Attempts
+	total.Connects += st.Connects
+	total.Failures += st.Failures
+	total.Total += st.Total
+	if st.Connects > 0 && (total.Minimum == 0 || st.Minimum < total.Minimum) {
+		total.Minimum = st.Minimum
+	}
+	total.Maximum = max(total.Maximum, st.Maximum)
+}
+
+func rtaStr(st *models.Stats) string {
+	if st.Connects == 0 {
+		return "-"
+	}
+	return helpers.DurStr(average(st))
+}
+
+// nagiosPerf returns the rta, pl, rtmin and rtmax performance data of st
+// with the labels prefixed by prefix.
+func nagiosPerf(prefix string, st *models.Stats, limits models.Limits) []string {
+	rta, rtmin, rtmax := "U", "U", "U"
+	if st.Connects > 0 {
+		rta, rtmin, rtmax = perfMs(average(st)), perfMs(st.Minimum), perfMs(st.Maximum)
+	}
+	loss := lossPct(st)
+	return []string{
+		perfLabel(prefix+"rta") + "=" + rta + ";" + perfLimit(limits.Avg, "%.3f") + ";0;",
+		perfLabel(prefix+"pl") + "=" + strconv.FormatFloat(loss, 'f', -1, 64) + "%;" + perfLimit(limits.Loss, "%g") + ";0;100",
+		perfLabel(prefix+"rtmin") + "=" + rtmin + ";;;0;",
+		perfLabel(prefix+"rtmax") + "=" + rtmax + ";;;0;",
+	}
+}
+
+func perfMs(d time.Duration) string {
+	return strconv.FormatFloat(helpers.Ms2Float64(d), 'f', 3, 64) + "ms"
+}
+
+// perfLimit renders the "warn;crit" part of a performance value.
+func perfLimit(l models.Limit, format string) string {
+	var warn, crit string
+	if l.HasWarn {
+		warn = fmt.Sprintf(format, l.Warn)
+	}
+	if l.HasCrit {
+		crit = fmt.Sprintf(format, l.Crit)
+	}
+	return warn + ";" + crit
+}
+
+var plainPerfLabel = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// perfLabel quotes labels with characters like ':' or '/' as the plugin
+// guidelines require.
+func perfLabel(label string) string {
+	if plainPerfLabel.MatchString(label) {
+		return label
+	}
+	return "'" + strings.ReplaceAll(label, "'", "") + "'"
+}
@@ -0,0 +1,14 @@
+package stats
+
+import (
+	"github.com/sopov/portping/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestNagiosPerf_NoAttempts(t *testing.T) {
+	perf := nagiosPerf("", &models.Stats{}, models.Limits{})
+	if len(perf) != 4 || perf[0] != "rta=U;;;0;" || !strings.HasPrefix(perf[1], "pl=0%;") {
+		t.Errorf("nagiosPerf() = %q, expected unknown rta and 0%% loss", perf)
+	}
+}
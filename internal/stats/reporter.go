@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/sopov/portping/internal/models"
+	"io"
+)
+
+// Reporter receives the events of a ping session and renders them to its
+// sink. The App calls OnStart once per target, OnResult for every attempt,
+// OnEvent for changes during the session such as DNS updates, and
+// OnSummary once per target when the session ends.
+type Reporter interface {
+	OnStart(cfg *models.Config)
+	OnResult(cfg *models.Config, r models.Result)
+	OnEvent(cfg *models.Config, ev models.Event)
+	OnSummary(cfg *models.Config, statsMap map[string]*models.Stats)
+}
+
+// Multi fans every event out to all of its reporters in order.
+type Multi []Reporter
+
+func (m Multi) OnStart(cfg *models.Config) {
+	for _, r := range m {
+		r.OnStart(cfg)
+	}
+}
+
+func (m Multi) OnResult(cfg *models.Config, res models.Result) {
+	for _, r := range m {
+		r.OnResult(cfg, res)
+	}
+}
+
+func (m Multi) OnEvent(cfg *models.Config, ev models.Event) {
+	for _, r := range m {
+		r.OnEvent(cfg, ev)
+	}
+}
+
+func (m Multi) OnSummary(cfg *models.Config, statsMap map[string]*models.Stats) {
+	for _, r := range m {
+		r.OnSummary(cfg, statsMap)
+	}
+}
+
+// NewReporter returns the built-in reporter for the given output format;
+// color only applies to text.
+func NewReporter(format models.Output, w io.Writer, color bool) (Reporter, error) {
+	switch format {
+	case models.OutputText, "":
+		return NewTextReporter(w, color), nil
+	case models.OutputJSON:
+		return NewJSONReporter(w, false), nil
+	case models.OutputNDJSON:
+		return NewJSONReporter(w, true), nil
+	case models.OutputCSV:
+		return NewCSVReporter(w), nil
+	case models.OutputNagios:
+		return NewNagiosReporter(w), nil
+	}
+	return nil, fmt.Errorf("invalid output format `%s`", format)
+}
+
+// resultPort returns the port probed by r, which is the target port unless
+// the target is a port sweep.
+func resultPort(cfg *models.Config, r models.Result) string {
+	if r.Port != "" {
+		return r.Port
+	}
+	return cfg.Port
+}
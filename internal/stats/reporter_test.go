@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"bytes"
+	"github.com/sopov/portping/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingReporter struct {
+	starts, results, events, summaries int
+}
+
+func (c *countingReporter) OnStart(_ *models.Config)                               { c.starts++ }
+func (c *countingReporter) OnResult(_ *models.Config, _ models.Result)             { c.results++ }
+func (c *countingReporter) OnEvent(_ *models.Config, _ models.Event)               { c.events++ }
+func (c *countingReporter) OnSummary(_ *models.Config, _ map[string]*models.Stats) { c.summaries++ }
+
+func TestMulti(t *testing.T) {
+	a, b := &countingReporter{}, &countingReporter{}
+	m := Multi{a, b}
+	cfg := &models.Config{}
+
+	m.OnStart(cfg)
+	m.OnResult(cfg, models.Result{})
+	m.OnResult(cfg, models.Result{})
+	m.OnEvent(cfg, models.Event{Kind: models.EventResolve})
+	m.OnSummary(cfg, nil)
+
+	for i, c := range []*countingReporter{a, b} {
+		if c.starts != 1 || c.results != 2 || c.events != 1 || c.summaries != 1 {
+			t.Errorf("reporter %d got starts=%d results=%d events=%d summaries=%d, expected 1/2/1/1",
+				i, c.starts, c.results, c.events, c.summaries)
+		}
+	}
+}
+
+func TestNewReporter(t *testing.T) {
+	tests := []struct {
+		format  models.Output
+		wantErr bool
+	}{
+		{models.OutputText, false},
+		{models.OutputJSON, false},
+		{models.OutputNDJSON, false},
+		{models.OutputCSV, false},
+		{models.Output("xml"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			r, err := NewReporter(tt.format, &bytes.Buffer{}, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewReporter(%q) error = %v, wantErr %v", tt.format, err, tt.wantErr)
+			}
+			if !tt.wantErr && r == nil {
+				t.Errorf("NewReporter(%q) returned nil reporter", tt.format)
+			}
+		})
+	}
+}
+
+func TestCSVReporter(t *testing.T) {
+	cfg := &models.Config{Proto: models.TCP, Port: "80"}
+	ip := models.IP{IP: "192.168.1.1", IsIPv4: true}
+	var buf bytes.Buffer
+	r := NewCSVReporter(&buf)
+
+	r.OnStart(cfg)
+	r.OnResult(cfg, models.Result{Attempt: 1, IP: ip, Time: time.Now(), Duration: 10 * time.Millisecond})
+	r.OnSummary(cfg, nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected header and one row, got %q", buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "time,attempt,") {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if !strings.Contains(lines[1], ",192.168.1.1,ipv4,tcp,80,10.000,true,") {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+}
+
+func TestCSVReporter_MultiTarget(t *testing.T) {
+	ip := models.IP{IP: "127.0.0.1", IsIPv4: true}
+	var buf bytes.Buffer
+	r := NewCSVReporter(&buf)
+
+	targets := []*models.Config{
+		{Host: "127.0.0.1", Proto: models.TCP, Port: "18080"},
+		{Host: "127.0.0.1", Proto: models.TCP, Port: "18081"},
+	}
+	for _, cfg := range targets {
+		r.OnStart(cfg)
+	}
+	for _, cfg := range targets {
+		r.OnResult(cfg, models.Result{Attempt: 1, IP: ip, Time: time.Now()})
+	}
+	for _, cfg := range targets {
+		r.OnSummary(cfg, nil)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	headers := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, "time,attempt,") {
+			headers++
+		}
+	}
+	if headers != 1 || len(lines) != 3 {
+		t.Errorf("expected one header and two rows, got %q", buf.String())
+	}
+}
@@ -5,143 +5,592 @@ import (
 	"github.com/sopov/portping/internal/colors"
 	"github.com/sopov/portping/internal/helpers"
 	"github.com/sopov/portping/internal/models"
-	"sort"
+	"io"
 	"strconv"
+	"strings"
 	"time"
 )
 
-var (
+// TextReporter renders the session as colored, column-aligned text.
+// With several targets the live lines are prefixed with the target name.
+type TextReporter struct {
+	w             io.Writer
+	targets       int
+	maxIPLen      int
+	maxNameLen    int
+	srvGroup      string
 	okFmt, errFmt string
-)
+	c             colors.Palette
+}
 
-func ShowBanner(cfg *models.Config) {
+// NewTextReporter returns a text reporter writing to w, in color when color
+// is set.
+func NewTextReporter(w io.Writer, color bool) *TextReporter {
+	return &TextReporter{w: w, c: colors.NewPalette(color)}
+}
+
+func (r *TextReporter) OnStart(cfg *models.Config) {
+	r.targets++
 	ipSuffix := "IP"
 	if len(cfg.IPs) > 1 {
 		ipSuffix += "s"
 	}
-	fmt.Printf("Ping of %s on %s %s (%d %s)\n",
-		colors.HYellow(cfg.Host),
-		colors.HYellow(cfg.Proto),
-		colors.HYellow(cfg.Port),
+	fmt.Fprintf(r.w, "Ping of %s on %s %s (%d %s)\n",
+		r.c.HYellow(cfg.Host),
+		r.c.HYellow(cfg.Proto),
+		r.c.HYellow(cfg.Port),
 		len(cfg.IPs),
 		ipSuffix,
 	)
 
+	if srv := cfg.SRV; srv != nil {
+		fmt.Fprintf(r.w, "SRV: %s (priority %d, weight %d)\n", r.c.HYellow(srv.Name), srv.Priority, srv.Weight)
+	}
+
+	if src := cfg.SourceName(); src != "" {
+		fmt.Fprintf(r.w, "Source: %s\n", r.c.HYellow(src))
+	}
+
+	if res := cfg.Resolution; res != nil {
+		via := "system resolver"
+		if res.Server != "" {
+			via = res.Server
+			if cfg.ResolverTCP {
+				via += " (tcp)"
+			}
+		}
+		fmt.Fprintf(r.w, "DNS: %s via %s\n", r.c.HYellow(helpers.DurStr(res.Duration)), r.c.HYellow(via))
+		if len(res.CNAMEs) > 0 {
+			chain := append([]string{cfg.Host}, res.CNAMEs...)
+			fmt.Fprintf(r.w, "CNAME: %s\n", r.c.HYellow(strings.Join(chain, " -> ")))
+		}
+	}
+
 	for _, ip := range cfg.IPs {
 		t := "IPv4"
 		if ip.IsIPv6() {
 			t = "IPv6"
 		}
 
-		fmt.Printf("%s: %s\n", t, colors.HYellow(ip.IP))
+		fmt.Fprintf(r.w, "%s: %s\n", t, r.c.HYellow(ip.IP))
+	}
+
+	if cfg.IsSweep() {
+		fmt.Fprintf(r.w, "Ports: %s (%d)\n", r.c.HYellow(helpers.PortRanges(cfg.Ports)), len(cfg.Ports))
 	}
 
 	if cfg.IsUDP() {
-		fmt.Printf("Payload (hex): %s\n", colors.HYellow(cfg.UDPPayloadHex))
+		fmt.Fprintf(r.w, "Payload (hex): %s\n", r.c.HYellow(cfg.UDPPayloadHex))
+	}
+
+	if cfg.IsHTTP() {
+		fmt.Fprintf(r.w, "Request: %s %s\n", r.c.HYellow(cfg.HTTP.Method), r.c.HYellow(cfg.URL()))
+	}
+
+	if cfg.Banner {
+		expect := "any"
+		if cfg.Expect != nil {
+			expect = cfg.Expect.String()
+		}
+		fmt.Fprintf(r.w, "Banner: %s\n", r.c.HYellow(expect))
+	}
+
+	if cfg.TLS {
+		mode := "verify"
+		if cfg.TLSInsecure {
+			mode = "insecure"
+		}
+		fmt.Fprintf(r.w, "TLS: SNI %s (%s)\n", r.c.HYellow(cfg.Host), mode)
 	}
 
+	r.setFormats(cfg)
 }
 
-func ShowStats(cfg *models.Config, statsMap map[string]*models.Stats) {
+func (r *TextReporter) OnSummary(cfg *models.Config, statsMap map[string]*models.Stats) {
 	if len(statsMap) == 0 {
 		return
 	}
-
-	keys := make([]string, 0, len(statsMap))
-	for ip := range statsMap {
-		keys = append(keys, ip)
+	if cfg.IsSweep() {
+		r.showSweep(cfg, statsMap)
+		return
 	}
-	sort.Strings(keys)
 
+	rows := summaryRows(cfg, statsMap)
 	var maxLen int
-	for _, ip := range keys {
-		if l := len(ip); l > maxLen {
+	for _, row := range rows {
+		if l := len(row.label); l > maxLen {
 			maxLen = l
 		}
 	}
 
-	format := "% " + strconv.Itoa(maxLen+10) + "s% 12s% 21s % 24s% 10s %10s  %10s\n"
-	if cfg.NoColor {
-		format = "% " + strconv.Itoa(maxLen+1) + "s% 12s% 11s % 15s% 10s %10s  %10s\n"
+	format := "% " + strconv.Itoa(maxLen+10) + "s% 12s% 21s % 24s% 10s %10s  %10s"
+	if !r.c.Enabled() {
+		format = "% " + strconv.Itoa(maxLen+1) + "s% 12s% 11s % 15s% 10s %10s  %10s"
 	}
-	fmt.Printf(
-		"\nStatistics of ping %s on %s %s\n",
-		colors.HYellow(cfg.Host),
-		colors.HYellow(cfg.Proto),
-		colors.HYellow(cfg.Port))
+	format += " %10s %10s" + strings.Repeat(" %10s", len(Percentiles)) + "\n"
+	r.showSRVGroup(cfg)
+	fmt.Fprintf(r.w,
+		"\nStatistics of ping %s on %s %s%s\n",
+		r.c.HYellow(cfg.Host),
+		r.c.HYellow(cfg.Proto),
+		r.c.HYellow(cfg.Port),
+		srvStr(cfg))
 
-	fmt.Printf(format,
-		colors.Yellow("IP Address"),
+	header := []any{
+		r.c.Yellow("IP Address"),
 		"Attempted",
-		colors.Green("Connected"),
-		colors.Red("Failed"),
+		r.c.Green("Connected"),
+		r.c.Red("Failed"),
 		"Minimum",
 		"Maximum",
 		"Average",
-	)
+		"Mdev",
+		"Jitter",
+	}
+	for _, p := range Percentiles {
+		header = append(header, "P"+strconv.FormatFloat(p, 'f', -1, 64))
+	}
+	fmt.Fprintf(r.w, format, header...)
+
+	for _, row := range rows {
+		st := row.st
+		line := []any{
+			r.c.HYellow(row.label),                // IP
+			strconv.Itoa(st.Attempts),             // Attempts
+			r.c.HGreen(strconv.Itoa(st.Connects)), // Connected
+			fmt.Sprintf("%s % 6s", // Failed
+				r.c.HRed(strconv.Itoa(st.Failures)),
+				fmt.Sprintf("(%.2f%%)", 100*float64(st.Failures)/float64(st.Attempts))),
+			helpers.DurStr(st.Minimum),
+			helpers.DurStr(st.Maximum),
+			helpers.DurStr(average(st)),
+			helpers.DurStr(Mdev(st)),
+			helpers.DurStr(st.Jitter),
+		}
+		for _, p := range percentiles(st, Percentiles...) {
+			line = append(line, helpers.DurStr(p))
+		}
+		fmt.Fprintf(r.w, format, line...)
+	}
 
+	r.showErrors(rows, maxLen)
+	r.showStates(rows, maxLen)
+	r.showTWAMP(rows, maxLen)
+	switch {
+	case cfg.HappyEyeballs:
+		r.showWins(cfg, statsMap)
+	case cfg.Dual:
+		r.showFamilies(cfg, statsMap)
+	}
+	if stop := stopStr(cfg); stop != "" {
+		fmt.Fprintf(r.w, "\nStopped: %s\n", stop)
+	}
+	r.showCheck(cfg, statsMap)
+}
+
+// showCheck prints the status of the target against the -max-* limits
+// and the limits it exceeded.
+func (r *TextReporter) showCheck(cfg *models.Config, statsMap map[string]*models.Stats) {
+	if !cfg.Limits.IsSet() {
+		return
+	}
+	status, violations := Check(cfg, statsMap)
+	label := status.String()
+	switch status {
+	case models.StatusOK:
+		label = r.c.HGreen(label)
+	case models.StatusWarning:
+		label = r.c.HYellow(label)
+	default:
+		label = r.c.HRed(label)
+	}
+	fmt.Fprintf(r.w, "\nCheck: %s\n", label)
+	for _, v := range violations {
+		fmt.Fprintf(r.w, "  %-8s %s\n", v.Status, v)
+	}
+}
+
+// stopStr describes the condition that ended the pings of a target.
+func stopStr(cfg *models.Config) string {
+	switch cfg.Stop {
+	case models.StopCount:
+		return fmt.Sprintf("%d attempts done", cfg.Count)
+	case models.StopDeadline:
+		return fmt.Sprintf("deadline of %s expired", cfg.Deadline)
+	case models.StopSuccesses:
+		return fmt.Sprintf("%d successful attempts", cfg.Successes)
+	case models.StopFailures:
+		return fmt.Sprintf("%d consecutive failures", cfg.FailStreak)
+	case models.StopWait:
+		return "destination is " + string(cfg.Wait)
+	case models.StopInterrupted:
+		return "interrupted"
+	}
+	return ""
+}
+
+// showSRVGroup starts a new group of summaries when cfg is the first
+// target of an SRV name; the targets of a name follow each other.
+func (r *TextReporter) showSRVGroup(cfg *models.Config) {
+	name := ""
+	if cfg.SRV != nil {
+		name = cfg.SRV.Name
+	}
+	if name != "" && name != r.srvGroup {
+		fmt.Fprintf(r.w, "\n--- SRV %s ---\n", r.c.HYellow(name))
+	}
+	r.srvGroup = name
+}
+
+// srvStr describes the SRV record of a target in a summary header.
+func srvStr(cfg *models.Config) string {
+	if cfg.SRV == nil {
+		return ""
+	}
+	return fmt.Sprintf(" (priority %d, weight %d)", cfg.SRV.Priority, cfg.SRV.Weight)
+}
+
+// tableRow is a labeled line of the statistics table.
+type tableRow struct {
+	label string
+	st    *models.Stats
+}
+
+// summaryRows returns the rows of the statistics table: one per IP with
+// attempts, or the race totals in Happy Eyeballs mode.
+func summaryRows(cfg *models.Config, statsMap map[string]*models.Stats) []tableRow {
+	var rows []tableRow
+	if cfg.HappyEyeballs {
+		if st := statsMap[models.RaceKey]; st != nil && st.Attempts > 0 {
+			rows = append(rows, tableRow{label: "race", st: st})
+		}
+		return rows
+	}
+	for _, ip := range cfg.IPs {
+		if st := statsMap[ip.IP]; st != nil && st.Attempts > 0 {
+			label := ip.IP
+			if st.Retired {
+				label += " (retired)"
+			}
+			rows = append(rows, tableRow{label: label, st: st})
+		}
+	}
+	return rows
+}
+
+// showErrors prints the per-category failure counters of every row that
+// had failures.
+func (r *TextReporter) showErrors(rows []tableRow, maxLen int) {
+	header := false
+	for _, row := range rows {
+		st := row.st
+		if len(st.Errors) == 0 {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(r.w, "\nFailures by category\n")
+			header = true
+		}
+		parts := make([]string, 0, len(st.Errors))
+		for _, class := range models.ErrClasses {
+			if n := st.Errors[class]; n > 0 {
+				parts = append(parts, fmt.Sprintf("%s %s", class, r.c.HRed(strconv.Itoa(n))))
+			}
+		}
+		fmt.Fprintf(r.w, "% "+strconv.Itoa(maxLen+1)+"s  %s\n", row.label, strings.Join(parts, ", "))
+	}
+}
+
+// showStates prints the number of attempts per port state of every row
+// with known states, e.g. of UDP pings.
+func (r *TextReporter) showStates(rows []tableRow, maxLen int) {
+	header := false
+	for _, row := range rows {
+		st := row.st
+		if len(st.States) == 0 {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(r.w, "\nPort states\n")
+			header = true
+		}
+		parts := make([]string, 0, len(st.States))
+		for _, state := range models.PortStates {
+			if n := st.States[state]; n > 0 {
+				count := strconv.Itoa(n)
+				if state == models.StateOpen {
+					count = r.c.HGreen(count)
+				}
+				parts = append(parts, fmt.Sprintf("%s %s", state, count))
+			}
+		}
+		fmt.Fprintf(r.w, "% "+strconv.Itoa(maxLen+1)+"s  %s\n", row.label, strings.Join(parts, ", "))
+	}
+}
+
+// showTWAMP prints the counters of the two-way echo sessions.
+func (r *TextReporter) showTWAMP(rows []tableRow, maxLen int) {
+	header := false
+	indent := strings.Repeat(" ", maxLen+1)
+	for _, row := range rows {
+		seq := row.st.Seq
+		if seq == nil {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(r.w, "\nTwo-way session (forward = to the reflector, reverse = back)\n")
+			header = true
+		}
+		fmt.Fprintf(r.w, "% "+strconv.Itoa(maxLen+1)+"s  sent %d, received %d, reflected %d\n",
+			row.label, seq.Sent, seq.Received, seq.Reflected)
+		fmt.Fprintf(r.w, "%s  loss forward %s, reverse %s, unknown %d\n",
+			indent, r.c.HRed(strconv.Itoa(seq.ForwardLoss)), r.c.HRed(strconv.Itoa(seq.ReverseLoss)), seq.Unknown)
+		fmt.Fprintf(r.w, "%s  reordered %d, duplicates %d, late %d\n",
+			indent, seq.Reordered, seq.Duplicates, seq.Late)
+		fmt.Fprintf(r.w, "%s  jitter forward %s, reverse %s\n",
+			indent, helpers.DurStr(seq.ForwardJitter), helpers.DurStr(seq.ReverseJitter))
+	}
+}
+
+// showSweep prints the port states of a sweep: a table with the number of
+// ports per state and IP, then the ports of every state as ranges.
+func (r *TextReporter) showSweep(cfg *models.Config, statsMap map[string]*models.Stats) {
+	states := []models.PortState{models.StateOpen, models.StateClosed, models.StateFiltered}
+	if cfg.IsUDP() {
+		states[2] = models.StateOpenFiltered
+	}
+
+	var maxLen int
+	for _, ip := range cfg.IPs {
+		if l := len(ip.IP); l > maxLen {
+			maxLen = l
+		}
+	}
+	ipCol := "% " + strconv.Itoa(maxLen+1) + "s"
+	if r.c.Enabled() {
+		ipCol = "% " + strconv.Itoa(maxLen+10) + "s"
+	}
+
+	fmt.Fprintf(r.w,
+		"\nPort sweep of %s on %s %s\n",
+		r.c.HYellow(cfg.Host),
+		r.c.HYellow(cfg.Proto),
+		r.c.HYellow(cfg.Port))
+	fmt.Fprintf(r.w, ipCol, r.c.Yellow("IP Address"))
+	for _, state := range states {
+		fmt.Fprintf(r.w, " % 14s", state)
+	}
+	fmt.Fprintln(r.w)
 	for _, ip := range cfg.IPs {
 		st := statsMap[ip.IP]
 		if st == nil || st.Attempts == 0 {
 			continue
 		}
-		var avg time.Duration
-		if st.Connects > 0 {
-			avg = time.Duration(float64(st.Total) / float64(st.Connects))
+		fmt.Fprintf(r.w, ipCol, r.c.HYellow(ip.IP))
+		for _, state := range states {
+			fmt.Fprintf(r.w, " % 14d", st.States[state])
 		}
+		fmt.Fprintln(r.w)
+	}
 
-		fmt.Printf(
-			format,
-			colors.HYellow(ip.IP),                    // IP
-			strconv.Itoa(st.Attempts),                // Attempts
-			colors.HGreen(strconv.Itoa(st.Connects)), // Connected
-			fmt.Sprintf("%s % 6s", // Failed
-				colors.HRed(strconv.Itoa(st.Failures)),
-				fmt.Sprintf("(%.2f%%)", 100*float64(st.Failures)/float64(st.Attempts))),
-			helpers.DurStr(st.Minimum),
-			helpers.DurStr(st.Maximum),
-			helpers.DurStr(avg),
-		)
+	for _, ip := range cfg.IPs {
+		st := statsMap[ip.IP]
+		if st == nil || st.Attempts == 0 {
+			continue
+		}
+		fmt.Fprintf(r.w, "\n%s\n", r.c.HYellow(ip.IP))
+		for _, state := range states {
+			var ports []int
+			for _, port := range cfg.Ports {
+				if st.Ports[strconv.Itoa(port)] == state {
+					ports = append(ports, port)
+				}
+			}
+			if len(ports) == 0 {
+				continue
+			}
+			list := helpers.PortRanges(ports)
+			if state == models.StateOpen {
+				list = r.c.HGreen(list)
+			}
+			fmt.Fprintf(r.w, "% 14s  %s\n", state, list)
+		}
 	}
 }
 
-func showCurrentFmt(cfg *models.Config, maxIPLen int, ok bool) string {
-	if okFmt == "" {
-		postMsgLen := "19"
-		if cfg.NoColor {
-			postMsgLen = "10"
+func average(st *models.Stats) time.Duration {
+	if st.Connects == 0 {
+		return 0
+	}
+	return time.Duration(float64(st.Total) / float64(st.Connects))
+}
+
+// setFormats widens the live line columns to fit cfg; it is called for
+// every target so that the lines of all targets stay aligned.
+func (r *TextReporter) setFormats(cfg *models.Config) {
+	for _, ip := range cfg.IPs {
+		if l := len(ip.IP); l > r.maxIPLen {
+			r.maxIPLen = l
 		}
-		okFmt = "% 3s\t%" + strconv.Itoa(maxIPLen) + "s\t%" + postMsgLen + "s"
-		errFmt = okFmt + "\tErr: %s\n"
-		okFmt += "%s\n"
+	}
+	if l := len(cfg.Name()); l > r.maxNameLen {
+		r.maxNameLen = l
 	}
 
-	if ok {
-		return okFmt
+	postMsgLen := "19"
+	if !r.c.Enabled() {
+		postMsgLen = "10"
 	}
-	return errFmt
+	r.okFmt = "% 3s\t"
+	if r.targets > 1 {
+		r.okFmt += "%-" + strconv.Itoa(r.maxNameLen) + "s\t"
+	}
+	r.okFmt += "%" + strconv.Itoa(r.maxIPLen) + "s\t%" + postMsgLen + "s"
+	r.errFmt = r.okFmt + "\tErr: %s\n"
+	r.okFmt += "%s\n"
 }
 
-func ShowCurrent(cfg *models.Config, cnt, subcnt int, ip string, maxIPLen int, duration time.Duration, err error) {
+func (r *TextReporter) OnResult(cfg *models.Config, res models.Result) {
+	if cfg.IsSweep() {
+		return // summarized as a port matrix
+	}
+	if r.okFmt == "" {
+		r.setFormats(cfg)
+	}
+
+	format := r.okFmt
 	errMsg := ""
-	durStr := helpers.DurStr(duration)
-	if err != nil {
-		errMsg = colors.Red(err.Error())
-		durStr = colors.HRed(durStr)
+	durStr := helpers.DurStr(res.Duration)
+	if res.Err != nil {
+		format = r.errFmt
+		errMsg = r.c.Red(res.Err.Error())
+		if res.ErrClass != models.ErrNone && res.ErrClass != models.ErrOther {
+			errMsg = "[" + res.ErrClass.String() + "] " + errMsg
+		}
+		if res.State != "" {
+			errMsg = r.c.HYellow(res.State.String()) + " " + errMsg
+		}
+		durStr = r.c.HRed(durStr)
 	} else {
-		durStr = colors.HGreen(durStr)
-	}
-	attempt := strconv.Itoa(cnt)
-	if subcnt > 0 {
-		attempt += "." + strconv.Itoa(subcnt)
-	}
-	fmt.Printf(
-		showCurrentFmt(cfg, maxIPLen, err == nil),
-		attempt,
-		ip,
-		durStr,
-		errMsg,
-	)
+		durStr = r.c.HGreen(durStr)
+		errMsg = r.detailsStr(cfg, res.Details)
+		if res.State != "" {
+			errMsg = "\t" + r.c.HGreen(res.State.String()) + errMsg
+		}
+	}
+	attempt := strconv.Itoa(res.Attempt)
+	if res.Sub > 0 {
+		attempt += "." + strconv.Itoa(res.Sub)
+	}
+	args := []any{attempt}
+	if r.targets > 1 {
+		args = append(args, cfg.Name())
+	}
+	args = append(args, res.IP.IP, durStr, errMsg)
+	fmt.Fprintf(r.w, format, args...)
+}
+
+// OnEvent prints session changes between the attempt lines.
+func (r *TextReporter) OnEvent(cfg *models.Config, ev models.Event) {
+	if ev.Kind != models.EventResolve {
+		return
+	}
+	if ev.Err != nil {
+		fmt.Fprintf(r.w, "DNS %s: %s\n", r.c.HYellow(cfg.Host), r.c.Red(ev.Err.Error()))
+		return
+	}
+	changes := make([]string, 0, len(ev.Added)+len(ev.Removed))
+	for _, ip := range ev.Added {
+		changes = append(changes, r.c.HGreen("+"+ip.IP))
+	}
+	for _, ip := range ev.Removed {
+		changes = append(changes, r.c.HRed("-"+ip.IP))
+	}
+	fmt.Fprintf(r.w, "DNS %s changed: %s\n", r.c.HYellow(cfg.Host), strings.Join(changes, " "))
+	r.setFormats(cfg)
+}
+
+// detailsStr renders probe details after the duration of a successful
+// attempt. Warnings are always shown, the rest only in verbose mode.
+func (r *TextReporter) detailsStr(cfg *models.Config, d *models.Details) string {
+	if d == nil {
+		return ""
+	}
+	var parts []string
+	if cfg.Verbose && d.HTTP != nil {
+		parts = append(parts,
+			"status="+strconv.Itoa(d.HTTP.Status),
+			d.HTTP.Proto,
+			"ttfb="+helpers.DurStr(d.HTTP.TTFB),
+			"bytes="+strconv.FormatInt(d.HTTP.Bytes, 10),
+		)
+	}
+	if cfg.Verbose && d.TLS != nil {
+		parts = append(parts,
+			"connect="+helpers.DurStr(d.Connect),
+			"tls="+helpers.DurStr(d.TLS.Handshake),
+			d.TLS.Version,
+			d.TLS.CipherSuite,
+		)
+		if d.TLS.ALPN != "" {
+			parts = append(parts, "alpn="+d.TLS.ALPN)
+		}
+		if d.TLS.Subject != "" {
+			parts = append(parts, fmt.Sprintf("cert=%q", d.TLS.Subject),
+				"expires="+d.TLS.NotAfter.UTC().Format(time.DateOnly))
+		}
+	}
+	if cfg.Verbose && d.UDP != nil {
+		parts = append(parts, "bytes="+strconv.Itoa(d.UDP.Bytes))
+		if d.UDP.RCode != "" {
+			parts = append(parts, "rcode="+d.UDP.RCode, "answers="+strconv.Itoa(d.UDP.Answers))
+		}
+		if d.UDP.Stratum > 0 {
+			parts = append(parts, "stratum="+strconv.Itoa(d.UDP.Stratum),
+				fmt.Sprintf("offset=%+.2fms", helpers.Ms2Float64(d.UDP.Offset)))
+		}
+		if d.UDP.Mapped != "" {
+			parts = append(parts, "mapped="+d.UDP.Mapped)
+		}
+		if d.UDP.Stamped {
+			parts = append(parts, "fwd="+helpers.DurStr(d.UDP.Forward), "rev="+helpers.DurStr(d.UDP.Reverse))
+		}
+	}
+	if cfg.Verbose && d.Banner != "" {
+		parts = append(parts, fmt.Sprintf("banner=%q", d.Banner))
+	}
+	if d.Warning != "" {
+		parts = append(parts, r.c.HYellow("Warn: "+d.Warning))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "\t" + strings.Join(parts, " ")
+}
+
+// Add accounts a single attempt, including its failure category and port
+// state.
+func Add(stats *models.Stats, r models.Result) {
+	Update(stats, r.Duration, r.Err)
+	if r.State != "" {
+		if stats.States == nil {
+			stats.States = make(map[models.PortState]int)
+			stats.Ports = make(map[string]models.PortState)
+		}
+		stats.States[r.State]++
+		stats.Ports[r.Port] = r.State
+	}
+	if r.Err == nil {
+		return
+	}
+	if stats.Errors == nil {
+		stats.Errors = make(map[models.ErrClass]int)
+	}
+	class := r.ErrClass
+	if class == models.ErrNone {
+		class = models.ErrOther
+	}
+	stats.Errors[class]++
 }
 
 func Update(stats *models.Stats, duration time.Duration, err error) {
@@ -153,6 +602,9 @@ func Update(stats *models.Stats, duration time.Duration, err error) {
 
 	stats.Connects++
 	stats.Total += duration
+	stats.SumSquares += float64(duration) * float64(duration)
+	addSample(stats, duration)
+	updateJitter(stats, duration)
 
 	if float64(stats.Minimum) == 0 || stats.Minimum > duration {
 		stats.Minimum = duration
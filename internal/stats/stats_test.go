@@ -1,8 +1,14 @@
 package stats
 
 import (
+	"bytes"
 	"errors"
+	"github.com/fatih/color"
+	"github.com/sopov/portping/internal/helpers"
 	"github.com/sopov/portping/internal/models"
+	"io"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -117,7 +123,7 @@ func TestUpdate_Mixed(t *testing.T) {
 	}
 }
 
-func TestShowStats_EmptyStats(_ *testing.T) {
+func TestTextReporter_OnSummary_EmptyStats(_ *testing.T) {
 	cfg := &models.Config{
 		Host:    "example.com",
 		Port:    "80",
@@ -129,10 +135,10 @@ func TestShowStats_EmptyStats(_ *testing.T) {
 	stats := make(map[string]*models.Stats)
 
 	// No panic with empty stats
-	ShowStats(cfg, stats)
+	NewTextReporter(io.Discard, false).OnSummary(cfg, stats)
 }
 
-func TestShowStats_WithNilEntry(_ *testing.T) {
+func TestTextReporter_OnSummary_WithNilEntry(_ *testing.T) {
 	cfg := &models.Config{
 		Host:    "example.com",
 		Port:    "80",
@@ -145,10 +151,10 @@ func TestShowStats_WithNilEntry(_ *testing.T) {
 	// Add nil entry -- not panic
 	stats["192.168.1.1"] = nil
 
-	ShowStats(cfg, stats)
+	NewTextReporter(io.Discard, false).OnSummary(cfg, stats)
 }
 
-func TestShowStats_WithZeroAttempts(_ *testing.T) {
+func TestTextReporter_OnSummary_WithZeroAttempts(_ *testing.T) {
 	cfg := &models.Config{
 		Host:    "example.com",
 		Port:    "80",
@@ -164,21 +170,52 @@ func TestShowStats_WithZeroAttempts(_ *testing.T) {
 	}
 
 	// Should not show stats with 0 attempts
-	ShowStats(cfg, stats)
+	NewTextReporter(io.Discard, false).OnSummary(cfg, stats)
 }
 
-func TestShowCurrent(_ *testing.T) {
+func TestTextReporter_OnResult(t *testing.T) {
 	cfg := &models.Config{
 		NoColor: true,
+		IPs:     []models.IP{{IP: "192.168.1.1", IsIPv4: true}},
+	}
+	ip := cfg.IPs[0]
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
+
+	r.OnResult(cfg, models.Result{Attempt: 1, IP: ip, Duration: 10 * time.Millisecond})
+	r.OnResult(cfg, models.Result{Attempt: 2, IP: ip, Duration: 100 * time.Millisecond, Err: errors.New("timeout")})
+	r.OnResult(cfg, models.Result{Attempt: 1, Sub: 1, IP: ip, Duration: 15 * time.Millisecond})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %q", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[1], "Err: timeout") {
+		t.Errorf("expected error message in line %q", lines[1])
+	}
+	if !strings.HasPrefix(strings.TrimSpace(lines[2]), "1.1") {
+		t.Errorf("expected sub attempt number in line %q", lines[2])
 	}
-	maxIPLen := 15
+}
+
+func TestTextReporter_Color(t *testing.T) {
+	original := color.NoColor
+	defer func() { color.NoColor = original }()
+	color.NoColor = false
 
-	ShowCurrent(cfg, 1, 0, "192.168.1.1", maxIPLen, 10*time.Millisecond, nil)
-	ShowCurrent(cfg, 2, 0, "192.168.1.1", maxIPLen, 100*time.Millisecond, errors.New("timeout"))
-	ShowCurrent(cfg, 1, 1, "192.168.1.1", maxIPLen, 15*time.Millisecond, nil)
+	cfg := &models.Config{Host: "example.com", Port: "80", Proto: models.TCP, IPs: []models.IP{{IP: "192.168.1.1", IsIPv4: true}}}
+	var term, file bytes.Buffer
+	NewTextReporter(&term, true).OnStart(cfg)
+	NewTextReporter(&file, false).OnStart(cfg)
+	if !strings.Contains(term.String(), "\x1b[") {
+		t.Errorf("expected color codes for the terminal, got %q", term.String())
+	}
+	if strings.Contains(file.String(), "\x1b[") {
+		t.Errorf("expected no color codes for the file, got %q", file.String())
+	}
 }
 
-func TestShowBanner(_ *testing.T) {
+func TestTextReporter_OnStart(t *testing.T) {
 	cfg := &models.Config{
 		Host:  "example.com",
 		Port:  "80",
@@ -188,13 +225,324 @@ func TestShowBanner(_ *testing.T) {
 		},
 		NoColor: true,
 	}
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
 
-	ShowBanner(cfg)
+	r.OnStart(cfg)
+	if !strings.Contains(buf.String(), "(1 IP)") {
+		t.Errorf("unexpected banner %q", buf.String())
+	}
 
 	cfg.IPs = []models.IP{
 		{IP: "192.168.1.1", IsIPv4: true},
 		{IP: "::1", IsIPv4: false},
 	}
 
-	ShowBanner(cfg)
+	buf.Reset()
+	r.OnStart(cfg)
+	if !strings.Contains(buf.String(), "(2 IPs)") || !strings.Contains(buf.String(), "IPv6: ::1") {
+		t.Errorf("unexpected banner %q", buf.String())
+	}
+}
+
+func TestAdd_ErrorCategories(t *testing.T) {
+	s := &models.Stats{}
+
+	Add(s, models.Result{Duration: 10 * time.Millisecond})
+	Add(s, models.Result{Err: errors.New("timeout"), ErrClass: models.ErrTimeout})
+	Add(s, models.Result{Err: errors.New("timeout"), ErrClass: models.ErrTimeout})
+	Add(s, models.Result{Err: errors.New("refused"), ErrClass: models.ErrRefused})
+	Add(s, models.Result{Err: errors.New("unclassified")})
+
+	if s.Attempts != 5 || s.Connects != 1 || s.Failures != 4 {
+		t.Errorf("Attempts/Connects/Failures = %d/%d/%d, expected 5/1/4", s.Attempts, s.Connects, s.Failures)
+	}
+	expected := map[models.ErrClass]int{
+		models.ErrTimeout: 2,
+		models.ErrRefused: 1,
+		models.ErrOther:   1,
+	}
+	if len(s.Errors) != len(expected) {
+		t.Errorf("Errors = %v, expected %v", s.Errors, expected)
+	}
+	for class, n := range expected {
+		if s.Errors[class] != n {
+			t.Errorf("Errors[%s] = %d, expected %d", class, s.Errors[class], n)
+		}
+	}
+}
+
+func TestTextReporter_OnSummary_ErrorCategories(t *testing.T) {
+	cfg := &models.Config{
+		Host:    "example.com",
+		Port:    "80",
+		Proto:   models.TCP,
+		IPs:     []models.IP{{IP: "192.168.1.1", IsIPv4: true}},
+		NoColor: true,
+	}
+	st := &models.Stats{IP: cfg.IPs[0]}
+	Add(st, models.Result{Err: errors.New("timeout"), ErrClass: models.ErrTimeout})
+	Add(st, models.Result{Err: errors.New("refused"), ErrClass: models.ErrRefused})
+
+	var buf bytes.Buffer
+	NewTextReporter(&buf, false).OnSummary(cfg, map[string]*models.Stats{"192.168.1.1": st})
+
+	if !strings.Contains(buf.String(), "timeout 1, refused 1") {
+		t.Errorf("expected per-category failures in summary, got %q", buf.String())
+	}
+}
+
+func TestTextReporter_MultipleTargets(t *testing.T) {
+	ip := models.IP{IP: "192.168.1.1", IsIPv4: true}
+	web := &models.Config{Host: "web", Port: "443", Proto: models.TCP, NoColor: true, IPs: []models.IP{ip}}
+	dns := &models.Config{Host: "ns", Port: "53", Proto: models.UDP, NoColor: true, IPs: []models.IP{ip}}
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
+
+	r.OnStart(web)
+	r.OnStart(dns)
+	buf.Reset()
+	r.OnResult(web, models.Result{Attempt: 1, Sub: 1, IP: ip, Duration: 10 * time.Millisecond})
+	r.OnResult(dns, models.Result{Attempt: 1, Sub: 2, IP: ip, Duration: 10 * time.Millisecond})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "web:443/tcp") || !strings.Contains(lines[1], "ns:53/udp") {
+		t.Errorf("expected target names in %q", lines)
+	}
+}
+
+func TestTextReporter_Sweep(t *testing.T) {
+	ip := models.IP{IP: "127.0.0.1", IsIPv4: true}
+	cfg := &models.Config{
+		Host: "localhost", Port: "20-25", Proto: models.TCP, NoColor: true,
+		IPs: []models.IP{ip}, Ports: []int{20, 21, 22, 23, 24, 25},
+	}
+	st := &models.Stats{IP: ip}
+	states := []models.PortState{
+		models.StateClosed, models.StateOpen, models.StateOpen,
+		models.StateClosed, models.StateClosed, models.StateFiltered,
+	}
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
+	r.OnStart(cfg)
+	for i, state := range states {
+		res := models.Result{Attempt: 1, Sub: i + 1, IP: ip, Port: strconv.Itoa(cfg.Ports[i]), State: state}
+		Add(st, res)
+		r.OnResult(cfg, res)
+	}
+	buf.Reset()
+	r.OnSummary(cfg, map[string]*models.Stats{ip.IP: st})
+
+	out := buf.String()
+	for _, want := range []string{"Port sweep of localhost on tcp 20-25", "open  21-22", "closed  20, 23-24", "filtered  25"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in summary:\n%s", want, out)
+		}
+	}
+	if st.States[models.StateClosed] != 3 || st.Ports["25"] != models.StateFiltered {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestTextReporter_DualStack(t *testing.T) {
+	v4 := models.IP{IP: "192.0.2.1", IsIPv4: true}
+	v6 := models.IP{IP: "2001:db8::1"}
+	cfg := &models.Config{Host: "example.com", Port: "443", Proto: models.TCP, NoColor: true, Dual: true,
+		IPs: []models.IP{v4, v6}}
+	statsMap := map[string]*models.Stats{v4.IP: {IP: v4}, v6.IP: {IP: v6}}
+	for i := 0; i < 4; i++ {
+		Update(statsMap[v4.IP], 10*time.Millisecond, nil)
+		Update(statsMap[v6.IP], 15*time.Millisecond, nil)
+	}
+	Update(statsMap[v6.IP], 0, errors.New("timeout"))
+
+	var buf bytes.Buffer
+	NewTextReporter(&buf, false).OnSummary(cfg, statsMap)
+
+	out := buf.String()
+	for _, want := range []string{"IPv4 vs IPv6", "+20.00%", "+5.00ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in summary:\n%s", want, out)
+		}
+	}
+}
+
+func TestTextReporter_HappyEyeballs(t *testing.T) {
+	v4 := models.IP{IP: "192.0.2.1", IsIPv4: true}
+	v6 := models.IP{IP: "2001:db8::1"}
+	cfg := &models.Config{Host: "example.com", Port: "443", Proto: models.TCP, NoColor: true,
+		HappyEyeballs: true, IPs: []models.IP{v4, v6}}
+	statsMap := map[string]*models.Stats{models.RaceKey: {}, v4.IP: {IP: v4}, v6.IP: {IP: v6}}
+	for _, ip := range []models.IP{v6, v6, v6, v4} {
+		res := models.Result{IP: ip, Duration: 10 * time.Millisecond}
+		Add(statsMap[models.RaceKey], res)
+		Add(statsMap[ip.IP], res)
+	}
+	Add(statsMap[models.RaceKey], models.Result{IP: v6, Err: errors.New("timeout"), ErrClass: models.ErrTimeout})
+
+	var buf bytes.Buffer
+	NewTextReporter(&buf, false).OnSummary(cfg, statsMap)
+
+	out := buf.String()
+	for _, want := range []string{"race", "Wins by address", "IPv6 3 (75.00%), IPv4 1 (25.00%)", "timeout 1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in summary:\n%s", want, out)
+		}
+	}
+}
+
+func TestTextReporter_ResolveEvent(t *testing.T) {
+	old := models.IP{IP: "192.0.2.1", IsIPv4: true}
+	added := models.IP{IP: "192.0.2.2", IsIPv4: true}
+	cfg := &models.Config{Host: "example.com", Port: "443", Proto: models.TCP, NoColor: true,
+		IPs: []models.IP{old, added}}
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
+
+	r.OnEvent(cfg, models.Event{Kind: models.EventResolve, Added: []models.IP{added}, Removed: []models.IP{old}})
+	if got := buf.String(); !strings.Contains(got, "DNS example.com changed: +192.0.2.2 -192.0.2.1") {
+		t.Errorf("unexpected event line %q", got)
+	}
+
+	statsMap := map[string]*models.Stats{old.IP: {IP: old, Retired: true}, added.IP: {IP: added}}
+	Update(statsMap[old.IP], 10*time.Millisecond, nil)
+	Update(statsMap[added.IP], 10*time.Millisecond, nil)
+	buf.Reset()
+	r.OnSummary(cfg, statsMap)
+	if !strings.Contains(buf.String(), "192.0.2.1 (retired)") || strings.Contains(buf.String(), "192.0.2.2 (retired)") {
+		t.Errorf("expected only the vanished IP to be retired:\n%s", buf.String())
+	}
+}
+
+func TestTextReporter_OnStart_Resolution(t *testing.T) {
+	cfg := &models.Config{
+		Host:        "www.example.com",
+		Port:        "443",
+		Proto:       models.TCP,
+		IPs:         []models.IP{{IP: "192.0.2.10", IsIPv4: true}},
+		NoColor:     true,
+		ResolverTCP: true,
+		Resolution: &models.Resolution{
+			Server:   "10.0.0.53:53",
+			Duration: 3 * time.Millisecond,
+			CNAMEs:   []string{"edge.cdn.example", "node.cdn.example"},
+		},
+	}
+	var buf bytes.Buffer
+	NewTextReporter(&buf, false).OnStart(cfg)
+
+	out := buf.String()
+	for _, want := range []string{
+		"DNS: " + helpers.DurStr(3*time.Millisecond) + " via 10.0.0.53:53 (tcp)",
+		"CNAME: www.example.com -> edge.cdn.example -> node.cdn.example",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in banner %q", want, out)
+		}
+	}
+}
+
+func TestTextReporter_SRVGroups(t *testing.T) {
+	ip := models.IP{IP: "192.168.1.1", IsIPv4: true}
+	stats := map[string]*models.Stats{ip.IP: {IP: ip, Attempts: 1, Connects: 1}}
+	srv := func(host string, prio, weight uint16) *models.Config {
+		return &models.Config{
+			Host: host, Port: "5432", Proto: models.TCP, NoColor: true, IPs: []models.IP{ip},
+			SRV: &models.SRV{Name: "_postgres._tcp.db.internal", Priority: prio, Weight: weight},
+		}
+	}
+	targets := []*models.Config{
+		srv("db1.internal", 10, 60),
+		srv("db2.internal", 20, 0),
+		{Host: "cache", Port: "6379", Proto: models.TCP, NoColor: true, IPs: []models.IP{ip}},
+	}
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
+	for _, cfg := range targets {
+		r.OnSummary(cfg, stats)
+	}
+
+	out := buf.String()
+	if n := strings.Count(out, "--- SRV _postgres._tcp.db.internal ---"); n != 1 {
+		t.Errorf("expected one SRV group header, got %d in %q", n, out)
+	}
+	for _, want := range []string{
+		"Statistics of ping db1.internal on tcp 5432 (priority 10, weight 60)",
+		"Statistics of ping db2.internal on tcp 5432 (priority 20, weight 0)",
+		"Statistics of ping cache on tcp 6379\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+}
+
+func TestTextReporter_StopReason(t *testing.T) {
+	ip := models.IP{IP: "192.168.1.1", IsIPv4: true}
+	stats := map[string]*models.Stats{ip.IP: {IP: ip, Attempts: 3, Failures: 3}}
+	tests := []struct {
+		cfg  models.Config
+		want string
+	}{
+		{models.Config{Stop: models.StopCount, Count: 3}, "Stopped: 3 attempts done"},
+		{models.Config{Stop: models.StopDeadline, Deadline: 30 * time.Second}, "Stopped: deadline of 30s expired"},
+		{models.Config{Stop: models.StopSuccesses, Successes: 5}, "Stopped: 5 successful attempts"},
+		{models.Config{Stop: models.StopFailures, FailStreak: 3}, "Stopped: 3 consecutive failures"},
+		{models.Config{Stop: models.StopWait, Wait: models.WaitDown}, "Stopped: destination is down"},
+		{models.Config{Stop: models.StopInterrupted}, "Stopped: interrupted"},
+	}
+	for _, tt := range tests {
+		cfg := tt.cfg
+		cfg.Host, cfg.Port, cfg.Proto, cfg.NoColor = "example.com", "80", models.TCP, true
+		cfg.IPs = []models.IP{ip}
+		var buf bytes.Buffer
+		NewTextReporter(&buf, false).OnSummary(&cfg, stats)
+		if !strings.Contains(buf.String(), tt.want+"\n") {
+			t.Errorf("expected %q in %q", tt.want, buf.String())
+		}
+	}
+}
+
+func TestTextReporter_UDPStates(t *testing.T) {
+	cfg := &models.Config{
+		Host:    "example.com",
+		Port:    "9000",
+		Proto:   models.UDP,
+		IPs:     []models.IP{{IP: "192.168.1.1", IsIPv4: true}},
+		NoColor: true,
+	}
+	ip := cfg.IPs[0]
+	results := []models.Result{
+		{Attempt: 1, IP: ip, Port: "9000", Duration: time.Millisecond, State: models.StateOpen},
+		{Attempt: 2, IP: ip, Port: "9000", Duration: time.Millisecond, Err: errors.New("connection refused"),
+			ErrClass: models.ErrPortUnreachable, State: models.StateClosed},
+		{Attempt: 3, IP: ip, Port: "9000", Duration: time.Second, Err: errors.New("i/o timeout"),
+			ErrClass: models.ErrTimeout, State: models.StateOpenFiltered},
+		{Attempt: 4, IP: ip, Port: "9000", Duration: time.Second, Err: errors.New("i/o timeout"),
+			ErrClass: models.ErrTimeout, State: models.StateOpenFiltered},
+	}
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf, false)
+	st := &models.Stats{IP: ip}
+	for _, res := range results {
+		r.OnResult(cfg, res)
+		Add(st, res)
+	}
+	r.OnSummary(cfg, map[string]*models.Stats{ip.IP: st})
+
+	out := buf.String()
+	for _, want := range []string{
+		"\topen\n",
+		"Err: closed [port-unreachable] connection refused",
+		"Err: open|filtered [timeout] i/o timeout",
+		"Port states\n",
+		"open 1, closed 1, open|filtered 2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
 }